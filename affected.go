@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/blakebarnett/dytty/cli"
+)
+
+type AffectedCommand struct {
+	Base string `default:"origin/main" help:"The git revision to compare the working tree with." name:"base" placeholder:"REV" yaml:"base"`
+	JSON bool   `help:"Output as JSON."                                        name:"json"                          yaml:"json"`
+}
+
+// command runs the named program with args and returns its output.
+func command(name string, args ...string) ([]byte, errors.E) {
+	return commandWithInput(nil, name, args...)
+}
+
+// commandWithInput runs the named program with args and input as its standard
+// input and returns its output.
+func commandWithInput(input []byte, name string, args ...string) ([]byte, errors.E) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.WithDetails(err, "command", name, "args", args, "stderr", strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// git runs git with args and returns the non-empty lines of its output.
+func git(args ...string) ([]string, errors.E) {
+	out, errE := command("git", args...)
+	if errE != nil {
+		return nil, errE
+	}
+
+	lines := []string{}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
+
+// ChangedFiles returns the absolute paths of the files which differ between the
+// working tree and base, including untracked files and files deleted since base.
+func ChangedFiles(base string) ([]string, errors.E) {
+	root, errE := git("rev-parse", "--show-toplevel")
+	if errE != nil {
+		return nil, errE
+	}
+
+	diff, errE := git("diff", "--name-only", base)
+	if errE != nil {
+		return nil, errE
+	}
+
+	untracked, errE := git("ls-files", "--others", "--exclude-standard", "--full-name")
+	if errE != nil {
+		return nil, errE
+	}
+
+	files := []string{}
+	for _, file := range append(diff, untracked...) {
+		files = append(files, filepath.Join(root[0], file))
+	}
+
+	return files, nil
+}
+
+// isURL returns true if path is a URL and not a local path.
+func isURL(path string) bool {
+	return cli.IsURL(path)
+}
+
+// InputFiles returns the absolute paths of all files and directories the target
+// is rendered from: its ytt input paths including templates, its charts, its
+// post-render overlays and patches, vendored libraries, its metadata file, the
+// certificate and lock file of sealed secrets and all loaded config files. The
+// path patterns the input paths were matched from are included as well, so
+// that files deleted since are recognized.
+func (cli *CLI) InputFiles(target Target) ([]string, errors.E) {
+	app, errE := NewApp(target.Kind, target.App, target.Env, cli)
+	if errE != nil {
+		return nil, errE
+	}
+
+	err := app.SetTemplatePaths(cli)
+	if err != nil {
+		return nil, errors.WithDetails(err, "kind", target.Kind, "app", target.App, "env", target.Env)
+	}
+
+	paths := append(app.InputPaths(cli), app.PathPatterns...)
+	paths = append(paths, app.ChartPaths()...)
+	paths = append(paths, app.PostRenderPaths()...)
+
+	libraries, errE := cli.VendoredLibraryPaths()
+	if errE != nil {
+		return nil, errE
+	}
+
+	paths = append(paths, libraries...)
+
+	metadataPath, errE := app.MetadataPath(cli)
+	if errE != nil {
+		return nil, errE
+	}
+
+	paths = append(paths, metadataPath)
+
+	if len(app.Metadata.Secrets) > 0 && cli.Kinds.Apps.Secrets.Type == SecretTypeSealed {
+		cert, errE := app.sealedCertPath(cli)
+		if errE != nil {
+			return nil, errE
+		}
+
+		lock, errE := app.sealedLockPath(cli)
+		if errE != nil {
+			return nil, errE
+		}
+
+		if cert != "" && !isURL(cert) {
+			paths = append(paths, cert)
+		}
+
+		paths = append(paths, lock)
+	}
+
+	for _, config := range cli.configFiles {
+		paths = append(paths, config.Path)
+	}
+
+	files := []string{}
+
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		files = append(files, abs)
+	}
+
+	return files, nil
+}
+
+// affects returns true if changed is one of inputs or inside one of them.
+// Inputs can be glob patterns, which changed or a directory containing it match.
+func affects(inputs []string, changed string) bool {
+	for _, input := range inputs {
+		if changed == input || strings.HasPrefix(changed, input+string(filepath.Separator)) {
+			return true
+		}
+
+		for path := changed; ; path = filepath.Dir(path) {
+			if ok, _ := filepath.Match(input, path); ok {
+				return true
+			}
+
+			if path == filepath.Dir(path) {
+				break
+			}
+		}
+	}
+
+	return false
+}
+
+// AffectedTargets returns the targets which are rendered from any of the
+// changed files, given as absolute paths. Targets whose input files cannot be
+// resolved are logged and skipped, and an error counting them is returned
+// together with the other affected targets.
+func (cli *CLI) AffectedTargets(changed []string) ([]Target, errors.E) {
+	logger := cli.GetLoggingConfig().Logger
+
+	targets, errE := cli.Targets()
+	if errE != nil {
+		return nil, errE
+	}
+
+	affected := []Target{}
+	failed := 0
+
+	for _, target := range targets {
+		inputs, errE := cli.InputFiles(target)
+		if errE != nil {
+			failed++
+
+			logger.Error().Msgf("Cannot resolve input files of kind: %s, app: %s, env: %s: %s", target.Kind, target.App, target.Env, errE)
+
+			continue
+		}
+
+		for _, file := range changed {
+			if affects(inputs, file) {
+				affected = append(affected, target)
+
+				break
+			}
+		}
+	}
+
+	if failed > 0 {
+		return affected, errors.Errorf("input files of %d targets cannot be resolved", failed)
+	}
+
+	return affected, nil
+}
+
+func (c *AffectedCommand) Run(cli *CLI) errors.E {
+	logger := cli.GetLoggingConfig().Logger
+	logger.Info().Msgf("Affected targets since: %s", c.Base)
+
+	changed, errE := ChangedFiles(c.Base)
+	if errE != nil {
+		return errE
+	}
+
+	logger.Info().Msgf("Changed files: %s", changed)
+
+	// Affected targets are listed even when some targets cannot be resolved.
+	targets, resolveErrE := cli.AffectedTargets(changed)
+	if targets == nil {
+		return resolveErrE
+	}
+
+	if c.JSON {
+		data, err := json.MarshalIndent(targets, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, _ = fmt.Fprintf(os.Stdout, "%s\n", data)
+
+		return resolveErrE
+	}
+
+	for _, target := range targets {
+		_, _ = fmt.Fprintf(os.Stdout, "%s %s %s\n", target.Kind, target.App, target.Env)
+	}
+
+	return resolveErrE
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAffectedTargets(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":                        "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\n",
+		"envs/development/values.yaml":              "#@data/values\n---\n{}\n",
+		"apps/example/values.yaml":                  "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"apps/example/" + AppMetadataFile:           "environments: [dev]\nsecrets:\n  db:\n    password: db.password\n",
+		"apps/other/values.yaml":                    "#@data/values\n---\n{}\n",
+		"apps/other/" + AppMetadataFile:             "environments: [dev, prod]\n",
+		"templates/deployment.yaml":                 "kind: Deployment\n",
+		"envs/production/values.yaml":               "#@data/values\n---\n{}\n",
+		"apps/unrelated/" + AppMetadataFile:         "environments: []\n",
+		"apps/unrelated/values.yaml":                "#@data/values\n---\n{}\n",
+		"envs/integration/values.yaml":              "#@data/values\n---\n{}\n",
+		"apps/example/development/not-an-input.txt": "",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+	cli.Kinds.Apps.Paths.Optional = []string{basePath + "/apps/{{.Name}}/{{.Env.Name}}/*.yaml"}
+	cli.Kinds.Apps.Secrets = SecretsConfig{Type: SecretTypeSealed, Cert: basePath + "/certs/{{.Env.Name}}.pem", Lock: basePath + "/sealed.lock.yaml"}
+	cli.ConfigFileLoaded(filepath.Join(basePath, "teams.yaml"), []byte("environments: {}\n"))
+	cli.Environments = map[string]Environment{}
+
+	for _, env := range []string{"development", "integration", "production"} {
+		e := Environment{}
+		e.Paths.RequiredValues = []string{basePath + "/envs/{{.Env.Name}}/values.yaml"}
+		cli.Environments[env] = e
+	}
+
+	cases := map[string]struct {
+		reason  string
+		changed []string
+		want    []Target
+	}{
+		"Global": {
+			reason:  "Changes to global files should affect all targets",
+			changed: []string{"global/values.yaml"},
+			want: []Target{
+				{Kind: "apps", App: "example", Env: "development"},
+				{Kind: "apps", App: "other", Env: "development"},
+				{Kind: "apps", App: "other", Env: "production"},
+				{Kind: "apps", App: "unrelated", Env: "development"},
+				{Kind: "apps", App: "unrelated", Env: "integration"},
+				{Kind: "apps", App: "unrelated", Env: "production"},
+			},
+		},
+		"Env": {
+			reason:  "Changes to env files should affect all targets in the env",
+			changed: []string{"envs/production/values.yaml"},
+			want: []Target{
+				{Kind: "apps", App: "other", Env: "production"},
+				{Kind: "apps", App: "unrelated", Env: "production"},
+			},
+		},
+		"Template": {
+			reason:  "Changes to templates should affect the targets using them",
+			changed: []string{"templates/deployment.yaml"},
+			want:    []Target{{Kind: "apps", App: "example", Env: "development"}},
+		},
+		"Metadata": {
+			reason:  "Changes to app metadata should affect the app",
+			changed: []string{"apps/other/" + AppMetadataFile},
+			want: []Target{
+				{Kind: "apps", App: "other", Env: "development"},
+				{Kind: "apps", App: "other", Env: "production"},
+			},
+		},
+		"DeletedGlobMatch": {
+			reason:  "Deleted files matching a path pattern should affect the targets using it",
+			changed: []string{"apps/example/development/deleted.yaml"},
+			want:    []Target{{Kind: "apps", App: "example", Env: "development"}},
+		},
+		"ConfigFile": {
+			reason:  "Changes to any loaded config file should affect all targets",
+			changed: []string{"teams.yaml"},
+			want: []Target{
+				{Kind: "apps", App: "example", Env: "development"},
+				{Kind: "apps", App: "other", Env: "development"},
+				{Kind: "apps", App: "other", Env: "production"},
+				{Kind: "apps", App: "unrelated", Env: "development"},
+				{Kind: "apps", App: "unrelated", Env: "integration"},
+				{Kind: "apps", App: "unrelated", Env: "production"},
+			},
+		},
+		"SealedCert": {
+			reason:  "Changes to the certificate of sealed secrets should affect the targets with secrets sealed with it",
+			changed: []string{"certs/development.pem"},
+			want:    []Target{{Kind: "apps", App: "example", Env: "development"}},
+		},
+		"SealedLock": {
+			reason:  "Changes to the lock file of sealed values should affect the targets with sealed secrets",
+			changed: []string{"sealed.lock.yaml"},
+			want:    []Target{{Kind: "apps", App: "example", Env: "development"}},
+		},
+		"NotAnInput": {
+			reason:  "Changes to files which are not inputs should not affect any target",
+			changed: []string{"apps/example/development/not-an-input.txt"},
+			want:    []Target{},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			changed := []string{}
+			for _, file := range tc.changed {
+				changed = append(changed, filepath.Join(basePath, file))
+			}
+
+			got, err := cli.AffectedTargets(changed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nAffectedTargets(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestAffectedTargetsUnresolved(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":              "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\n",
+		"apps/example/values.yaml":        "#@data/values\n---\n{}\n",
+		"apps/example/" + AppMetadataFile: "environments: [dev]\n",
+		"apps/broken/values.yaml":         "#@data/values\n---\ntemplates: [missing.yaml]\n",
+		"apps/broken/" + AppMetadataFile:  "environments: [dev]\n",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+
+	got, err := cli.AffectedTargets([]string{filepath.Join(basePath, "global/values.yaml")})
+	if err == nil {
+		t.Errorf("AffectedTargets(...): expected an error for the unresolved target")
+	}
+
+	want := []Target{{Kind: "apps", App: "example", Env: "development"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("AffectedTargets(...): -want, +got:\n%s\n", diff)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+
+	yttfiles "carvel.dev/ytt/pkg/files"
+	"gitlab.com/tozd/go/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Chart is a vendored local Helm chart rendered as an input of the app.
+type Chart struct {
+	// Name is the release name.
+	Name string `yaml:"name"`
+	// Path is the chart directory.
+	Path      string `yaml:"path"`
+	Namespace string `yaml:"namespace"`
+	// Values is the dot-separated data values key holding the chart values,
+	// by default the release name.
+	Values string `yaml:"values"`
+}
+
+// ChartPaths returns the directories of the charts of the app.
+func (app *App) ChartPaths() []string {
+	paths := []string{}
+
+	for _, chart := range app.Metadata.Charts {
+		paths = append(paths, chart.Path)
+	}
+
+	return paths
+}
+
+// chartValues returns the data value at the dot-separated key, or an empty map
+// if it does not exist.
+func chartValues(values map[string]any, key string) (any, errors.E) {
+	var value any = values
+
+	for _, k := range strings.Split(key, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, errors.Errorf("data value %s is not a map", key)
+		}
+
+		value, ok = m[k]
+		if !ok || value == nil {
+			return map[string]any{}, nil
+		}
+	}
+
+	return value, nil
+}
+
+// helmTemplate renders the chart with values using helm template. Values can
+// hold decrypted secrets, so they are passed on standard input and never written
+// to disk.
+func helmTemplate(chart Chart, values any) ([]byte, errors.E) {
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	args := []string{"template", chart.Name, chart.Path, "--values", "-"}
+	if chart.Namespace != "" {
+		args = append(args, "--namespace", chart.Namespace)
+	}
+
+	return commandWithInput(data, "helm", args...)
+}
+
+// RenderCharts renders the charts of the app with values from its data values
+// and returns their manifests as plain YAML ytt input files.
+func (app *App) RenderCharts(cli *CLI) ([]*yttfiles.File, errors.E) {
+	files := []*yttfiles.File{}
+
+	if len(app.Metadata.Charts) == 0 {
+		return files, nil
+	}
+
+	values, err := ParseValues(app, cli)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, chart := range app.Metadata.Charts {
+		key := chart.Values
+		if key == "" {
+			key = chart.Name
+		}
+
+		chartValues, errE := chartValues(values, key)
+		if errE != nil {
+			return nil, errors.WithDetails(errE, "chart", chart.Name)
+		}
+
+		manifests, errE := helmTemplate(chart, chartValues)
+		if errE != nil {
+			return nil, errors.WithDetails(errE, "chart", chart.Name)
+		}
+
+		file, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource("charts/"+chart.Name+".yaml", manifests))
+		if err != nil {
+			return nil, errors.WithDetails(err, "chart", chart.Name)
+		}
+
+		// Helm output is not a ytt template, but ytt overlays still apply to it.
+		file.MarkTemplate(false)
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blakebarnett/dytty/cli"
+)
+
+// fakeHelm renders a ConfigMap named after the release with the given values as data.
+const fakeHelm = `#!/bin/sh
+echo "# Source: $3/templates/configmap.yaml"
+echo "kind: ConfigMap"
+echo "metadata:"
+echo "  name: $2"
+echo "data:"
+sed 's/^/  /'
+`
+
+// writeTestCommand installs an executable script as the named command for the test.
+func writeTestCommand(t *testing.T, name, script string) {
+	t.Helper()
+
+	bin := t.TempDir()
+	writeTestFiles(t, bin, map[string]string{name: script})
+
+	err := os.Chmod(filepath.Join(bin, name), 0o755) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRenderCharts(t *testing.T) {
+	writeTestCommand(t, "helm", fakeHelm)
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":       "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\ncache:\n  size: \"\"\n",
+		"apps/example/values.yaml": "#@data/values\n---\ntemplates: [deployment.yaml, overlay.yaml]\ncache:\n  size: small\n",
+		"apps/example/" + AppMetadataFile: `charts:
+  - name: redis
+    path: ../../charts/redis
+    values: cache
+`,
+		"charts/redis/Chart.yaml":   "name: redis\n",
+		"templates/deployment.yaml": "kind: Deployment\n",
+		"templates/overlay.yaml": `#@ load("@ytt:overlay", "overlay")
+#@overlay/match by=overlay.subset({"kind": "ConfigMap"})
+---
+metadata:
+  #@overlay/match missing_ok=True
+  namespace: cache
+`,
+	})
+
+	want := []string{cli.ResolvePath(filepath.Join(basePath, "apps/example"), "../../charts/redis")}
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+
+	app, errE := NewApp("apps", "example", "development", cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	if diff := cmp.Diff(want, app.ChartPaths()); diff != "" {
+		t.Errorf("ChartPaths(): -want, +got:\n%s\n", diff)
+	}
+
+	got, err := app.Render(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered := `kind: Deployment
+---
+kind: ConfigMap
+metadata:
+  name: redis
+  namespace: cache
+data:
+  size: small
+`
+	if diff := cmp.Diff(rendered, string(got)); diff != "" {
+		t.Errorf("Render(...): -want, +got:\n%s\n", diff)
+	}
+}
@@ -1,8 +1,12 @@
 package cli
 
 import (
+	"bytes"
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/alecthomas/kong"
@@ -10,27 +14,169 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// includeKey is the top-level configuration key listing other configuration
+// files (or globs) to load before the file containing it.
+const includeKey = "include"
+
 type ConfigFlag string
 
+// hasConfigPaths is implemented by configuration targets which contain paths
+// that should be resolved relative to the configuration file they are
+// defined in instead of the current working directory.
+type hasConfigPaths interface {
+	ConfigPathKeys() []string
+}
+
+// hasConfigSources is implemented by configuration targets which record the
+// configuration files and the environment variable overrides they are loaded from.
+type hasConfigSources interface {
+	ConfigFileLoaded(path string, data []byte)
+	ConfigEnvApplied(name, value string)
+}
+
+// configFileLoaded records the loaded configuration file at path in target.
+func configFileLoaded(target any, path string, data []byte) {
+	if t, ok := target.(hasConfigSources); ok {
+		t.ConfigFileLoaded(path, data)
+	}
+}
+
 func (c ConfigFlag) BeforeResolve(app *kong.Kong, ctx *kong.Context, trace *kong.Path) error {
 	path := string(ctx.FlagValue(trace.Flag).(ConfigFlag)) //nolint:forcetypeassert
 
-	file, err := os.Open(kong.ExpandPath(path))
+	found, err := FindConfig(path)
+	if err != nil {
+		return err
+	}
+
+	target := app.Model.Target.Addr().Interface()
+
+	keys := []string{}
+	if t, ok := target.(hasConfigPaths); ok {
+		keys = t.ConfigPathKeys()
+	}
+
+	err = loadConfig(found, target, keys, map[string]bool{})
+	if err != nil {
+		return err
+	}
+
+	return environOverrides(target)
+}
+
+// FindConfig returns the location of the configuration file at path. When path
+// is a bare file name which does not exist in the current working directory,
+// parent directories are searched for it up to the root of the filesystem.
+func FindConfig(path string) (string, error) {
+	expanded := kong.ExpandPath(path)
+
+	_, err := os.Stat(expanded)
+	if err == nil {
+		return expanded, nil
+	} else if filepath.Base(path) != path {
+		return "", errors.WithDetails(err, "path", path)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	for {
+		candidate := filepath.Join(dir, path)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.WithDetails(os.ErrNotExist, "path", path)
+		}
+
+		dir = parent
+	}
+}
+
+// loadConfig decodes the configuration file at path into target. Files listed
+// under the include key are loaded first, so the including file takes precedence.
+// Loaded files are recorded in that order if target implements hasConfigSources.
+// Values under any of the keys are resolved relative to the directory of path.
+func loadConfig(path string, target any, keys []string, loading map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if loading[abs] {
+		return errors.WithDetails(errors.New("config include cycle"), "path", path)
+	}
+
+	loading[abs] = true
+	defer delete(loading, abs)
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return errors.WithDetails(err, "path", path)
+	}
+
+	var doc yaml.Node
+
+	err = yaml.Unmarshal(data, &doc)
 	if err != nil {
 		return errors.WithDetails(err, "path", path)
 	}
 
-	defer func() {
-		err = file.Close()
+	if len(doc.Content) == 0 {
+		configFileLoaded(target, abs, data)
+
+		return nil
+	}
+
+	root := doc.Content[0]
+	dir := filepath.Dir(abs)
+
+	includes, err := popIncludes(root)
+	if err != nil {
+		return errors.WithDetails(err, "path", path)
+	}
+
+	for _, include := range includes {
+		matches, err := filepath.Glob(filepath.Join(dir, include))
 		if err != nil {
-			panic(err)
+			return errors.WithDetails(err, "path", path, "include", include)
 		}
-	}()
 
-	decoder := yaml.NewDecoder(file)
+		if matches == nil {
+			return errors.WithDetails(errors.New("no files found for include"), "path", path, "include", include)
+		}
+
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			err = loadConfig(match, target, keys, loading)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	configFileLoaded(target, abs, data)
+
+	resolvePaths(root, reflect.TypeOf(target), dir, keys)
+
+	data, err = yaml.Marshal(root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return decodeConfig(data, target)
+}
+
+func decodeConfig(data []byte, target any) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
 	decoder.KnownFields(true)
 
-	err = decoder.Decode(app.Model.Target.Addr().Interface())
+	err := decoder.Decode(target)
 	if err != nil {
 		var yamlErr *yaml.TypeError
 		if errors.As(err, &yamlErr) {
@@ -49,3 +195,156 @@ func (c ConfigFlag) BeforeResolve(app *kong.Kong, ctx *kong.Context, trace *kong
 
 	return nil
 }
+
+// popIncludes removes the include key from the mapping node and returns its values.
+func popIncludes(node *yaml.Node) ([]string, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != includeKey {
+			continue
+		}
+
+		value := node.Content[i+1]
+		node.Content = append(node.Content[:i], node.Content[i+2:]...)
+
+		includes := []string{}
+
+		switch value.Kind { //nolint:exhaustive
+		case yaml.ScalarNode:
+			includes = append(includes, value.Value)
+		case yaml.SequenceNode:
+			for _, v := range value.Content {
+				includes = append(includes, v.Value)
+			}
+		default:
+			return nil, errors.Errorf("%s must be a string or a list of strings", includeKey)
+		}
+
+		return includes, nil
+	}
+
+	return nil, nil
+}
+
+// resolvePaths rewrites all relative string values of struct fields of t with
+// any of the keys as YAML names, so that they are relative to dir instead of
+// the current working directory. Values of maps and interfaces are only
+// followed into struct types, so free-form values like vars are kept as is.
+func resolvePaths(node *yaml.Node, t reflect.Type, dir string, keys []string) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil {
+		return
+	}
+
+	switch node.Kind { //nolint:exhaustive
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			switch t.Kind() { //nolint:exhaustive
+			case reflect.Struct:
+				field, ok := yamlField(t, key.Value)
+				if !ok {
+					continue
+				}
+
+				for _, k := range keys {
+					if key.Value == k {
+						resolvePathValues(value, dir)
+					}
+				}
+
+				resolvePaths(value, field.Type, dir, keys)
+			case reflect.Map:
+				resolvePaths(value, t.Elem(), dir, keys)
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+
+		for _, v := range node.Content {
+			resolvePaths(v, t.Elem(), dir, keys)
+		}
+	}
+}
+
+// yamlField returns the field of the struct type t with the YAML name, also
+// from inlined structs, with its index relative to t.
+func yamlField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		fieldName, inline := yamlFieldName(t.Field(i))
+		if fieldName == name {
+			return t.Field(i), true
+		}
+
+		if inline {
+			ft := t.Field(i).Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				if field, ok := yamlField(ft, name); ok {
+					field.Index = append([]int{i}, field.Index...)
+
+					return field, true
+				}
+			}
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// IsURL returns true if value starts with a URL scheme, e.g. https://.
+func IsURL(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+
+	return ok && scheme != "" && !strings.ContainsAny(scheme, "/\\{")
+}
+
+func resolvePathValues(node *yaml.Node, dir string) {
+	switch node.Kind { //nolint:exhaustive
+	case yaml.ScalarNode:
+		node.Value = ResolvePath(dir, node.Value)
+	case yaml.SequenceNode:
+		for _, v := range node.Content {
+			if v.Kind == yaml.ScalarNode {
+				v.Value = ResolvePath(dir, v.Value)
+			}
+		}
+	}
+}
+
+// ResolvePath returns path joined to dir when it is relative. The result is made
+// relative to the current working directory when possible, so configuration
+// loaded from the working directory keeps its paths as written. URLs and
+// values starting with a template action, which can render to any path, are
+// returned as is.
+func ResolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "{{") || IsURL(path) {
+		return path
+	}
+
+	path = filepath.Join(dir, path)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(cwd, path)
+	if err != nil {
+		return path
+	}
+
+	return rel
+}
@@ -0,0 +1,265 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testConfig struct {
+	BasePath     string                     `yaml:"basePath"`
+	Environments map[string]testConfigPaths `yaml:"environments"`
+}
+
+type testConfigPaths struct {
+	Extends  string   `yaml:"extends,omitempty"`
+	Required []string `yaml:"required"`
+	Optional []string `yaml:"optional,omitempty"`
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+}
+
+func TestFindConfig(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".dytty.yaml":           "basePath: .\n",
+		"apps/example/app.yaml": "",
+	})
+
+	cases := map[string]struct {
+		reason string
+		dir    string
+		want   string
+	}{
+		"InWorkingDirectory": {
+			reason: "FindConfig should find the config in the working directory",
+			dir:    root,
+			want:   filepath.Join(root, ".dytty.yaml"),
+		},
+		"InParentDirectory": {
+			reason: "FindConfig should walk up to find the config",
+			dir:    filepath.Join(root, "apps", "example"),
+			want:   filepath.Join(root, ".dytty.yaml"),
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			chdir(t, tc.dir)
+
+			got, err := FindConfig(".dytty.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nFindConfig(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestFindConfigNotFound(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	_, err := FindConfig(".dytty-missing.yaml")
+	if err == nil {
+		t.Errorf("FindConfig should fail when no config exists")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".dytty.yaml":        "include:\n  - teams/*/dytty.yaml\nbasePath: ./base\nenvironments:\n  development:\n    required:\n      - envs/dev\n",
+		"teams/a/dytty.yaml": "environments:\n  integration:\n    required:\n      - envs/int\n",
+	})
+
+	cases := map[string]struct {
+		reason string
+		dir    string
+		want   testConfig
+	}{
+		"FromRoot": {
+			reason: "loadConfig should merge includes and keep paths relative to the root",
+			dir:    root,
+			want: testConfig{
+				BasePath: "base",
+				Environments: map[string]testConfigPaths{
+					"development": {Required: []string{"envs/dev"}},
+					"integration": {Required: []string{"teams/a/envs/int"}},
+				},
+			},
+		},
+		"FromSubdirectory": {
+			reason: "loadConfig should resolve paths relative to the config file",
+			dir:    filepath.Join(root, "teams", "a"),
+			want: testConfig{
+				BasePath: "../../base",
+				Environments: map[string]testConfigPaths{
+					"development": {Required: []string{"../../envs/dev"}},
+					"integration": {Required: []string{"envs/int"}},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			chdir(t, tc.dir)
+
+			path, err := FindConfig(".dytty.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := testConfig{}
+
+			err = loadConfig(path, &got, []string{"basePath", "required"}, map[string]bool{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nloadConfig(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestLoadConfigIncludeCycle(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a.yaml": "include: b.yaml\n",
+		"b.yaml": "include: a.yaml\n",
+	})
+
+	err := loadConfig(filepath.Join(root, "a.yaml"), &testConfig{}, nil, map[string]bool{})
+	if err == nil {
+		t.Errorf("loadConfig should fail on include cycles")
+	}
+}
+
+// testSourcesConfig records the configuration files and environment variables
+// it is loaded from.
+type testSourcesConfig struct {
+	BasePath     string                     `yaml:"basePath"`
+	Environments map[string]testConfigPaths `yaml:"environments"`
+
+	files []string
+	env   []string
+}
+
+func (c *testSourcesConfig) ConfigFileLoaded(path string, _ []byte) {
+	c.files = append(c.files, path)
+}
+
+func (c *testSourcesConfig) ConfigEnvApplied(name, value string) {
+	c.env = append(c.env, name+"="+value)
+}
+
+func TestLoadConfigSources(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".dytty.yaml":        "include:\n  - teams/*/dytty.yaml\nbasePath: ./base\n",
+		"teams/a/dytty.yaml": "environments:\n  integration:\n    required:\n      - envs/int\n",
+		"teams/b/dytty.yaml": "",
+	})
+
+	got := &testSourcesConfig{}
+
+	err := loadConfig(filepath.Join(root, ".dytty.yaml"), got, nil, map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(root, "teams", "a", "dytty.yaml"),
+		filepath.Join(root, "teams", "b", "dytty.yaml"),
+		filepath.Join(root, ".dytty.yaml"),
+	}
+	if diff := cmp.Diff(want, got.files); diff != "" {
+		t.Errorf("loadConfig should record loaded files with includes first: -want, +got:\n%s\n", diff)
+	}
+}
+
+type testPathsConfig struct {
+	Cert         string                     `yaml:"cert"`
+	Output       string                     `yaml:"output"`
+	Vars         map[string]string          `yaml:"vars"`
+	Values       map[string]any             `yaml:"values"`
+	Environments map[string]testConfigPaths `yaml:"environments"`
+}
+
+func TestLoadConfigPaths(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"config/dytty.yaml": `cert: https://example.com/cert.pem
+output: "{{.Env.Name}}/docs"
+vars:
+  output: out
+values:
+  chart:
+    output: out
+environments:
+  development:
+    required:
+      - envs/dev
+      - s3://bucket/values.yaml
+`,
+	})
+
+	chdir(t, root)
+
+	got := testPathsConfig{}
+
+	err := loadConfig(filepath.Join("config", "dytty.yaml"), &got, []string{"cert", "output", "required"}, map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testPathsConfig{
+		Cert:   "https://example.com/cert.pem",
+		Output: "{{.Env.Name}}/docs",
+		Vars:   map[string]string{"output": "out"},
+		Values: map[string]any{"chart": map[string]any{"output": "out"}},
+		Environments: map[string]testConfigPaths{
+			"development": {Required: []string{"config/envs/dev", "s3://bucket/values.yaml"}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("loadConfig should only resolve relative paths of config fields: -want, +got:\n%s\n", diff)
+	}
+}
@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// hasConfigEnvPrefix is implemented by configuration targets which can be
+// overridden with environment variables starting with the returned prefix.
+type hasConfigEnvPrefix interface {
+	ConfigEnvPrefix() string
+}
+
+// applyEnvOverrides decodes all environment variables starting with prefix into
+// target. The rest of the variable name is matched case-insensitively against
+// the YAML keys of target with underscores separating (and allowed within)
+// keys, e.g. PREFIX_KINDS_APPS_PATHS_REQUIRED_VALUES sets kinds.apps.paths.requiredValues.
+// Map keys are matched against existing keys with - written as _, e.g.
+// PREFIX_ENVIRONMENTS_PROD_US_EXTENDS sets environments.prod-us.extends,
+// otherwise a single lower-cased segment adds a new key. Values are parsed as
+// YAML, so lists can be set with flow syntax, e.g. "[a, b]", and a single value
+// sets a list with one element. Only the addressed value is set, other values
+// of map entries are kept. Variables which do not map to a configuration key
+// are ignored, as they can be bound to flags.
+// Applied variables are recorded if target implements hasConfigSources.
+func applyEnvOverrides(prefix string, environ []string, target any) error {
+	sort.Strings(environ)
+
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, prefix+"_") {
+			continue
+		}
+
+		segments := strings.Split(strings.ToLower(strings.TrimPrefix(name, prefix+"_")), "_")
+
+		keys, leaf, ok := envKeys(reflect.TypeOf(target), reflect.ValueOf(target), segments)
+		if !ok {
+			continue
+		}
+
+		var v yaml.Node
+
+		err := yaml.Unmarshal([]byte(value), &v)
+		if err != nil {
+			return errors.WithDetails(err, "env", name)
+		}
+
+		if len(v.Content) == 0 {
+			continue
+		}
+
+		node := v.Content[0]
+		if leaf.Kind() == reflect.Slice && node.Kind == yaml.ScalarNode {
+			node = &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{node}}
+		}
+
+		err = setValue(reflect.ValueOf(target), keys, node)
+		if err != nil {
+			return errors.WithDetails(err, "env", name)
+		}
+
+		if t, ok := target.(hasConfigSources); ok {
+			t.ConfigEnvApplied(name, value)
+		}
+	}
+
+	return nil
+}
+
+// envKeys maps lower-cased name segments to YAML keys of t and returns
+// them with the type of the value they point to. v is the current value of
+// type t, if any, used to match existing map keys.
+func envKeys(t reflect.Type, v reflect.Value, segments []string) ([]string, reflect.Type, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+
+		if v.IsValid() {
+			v = v.Elem()
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, t, t.Kind() != reflect.Struct && t.Kind() != reflect.Map
+	}
+
+	switch t.Kind() { //nolint:exhaustive
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, nil, false
+		}
+
+		// Prefer the longest existing key, so that keys containing - or _ win.
+		existing := []string{}
+		if v.IsValid() {
+			for _, k := range v.MapKeys() {
+				existing = append(existing, k.String())
+			}
+		}
+
+		sort.Slice(existing, func(i, j int) bool {
+			return len(existing[i]) > len(existing[j])
+		})
+
+		for _, key := range existing {
+			n := strings.Count(key, "-") + strings.Count(key, "_") + 1
+			if n > len(segments) || strings.Join(segments[:n], "_") != strings.ToLower(strings.ReplaceAll(key, "-", "_")) {
+				continue
+			}
+
+			keys, leaf, ok := envKeys(t.Elem(), v.MapIndex(reflect.ValueOf(key).Convert(t.Key())), segments[n:])
+			if ok {
+				return append([]string{key}, keys...), leaf, true
+			}
+		}
+
+		keys, leaf, ok := envKeys(t.Elem(), reflect.Value{}, segments[1:])
+		if !ok {
+			return nil, nil, false
+		}
+
+		return append([]string{segments[0]}, keys...), leaf, true
+	case reflect.Struct:
+		// Prefer the longest match, so that keys containing underscores win.
+		for n := len(segments); n > 0; n-- {
+			candidate := strings.Join(segments[:n], "")
+
+			for i := 0; i < t.NumField(); i++ {
+				name, inline := yamlFieldName(t.Field(i))
+				if inline || name == "-" || strings.ToLower(name) != candidate {
+					continue
+				}
+
+				keys, leaf, ok := envKeys(t.Field(i).Type, fieldValue(v, i), segments[n:])
+				if ok {
+					return append([]string{name}, keys...), leaf, true
+				}
+			}
+		}
+
+		for i := 0; i < t.NumField(); i++ {
+			if _, inline := yamlFieldName(t.Field(i)); inline {
+				keys, leaf, ok := envKeys(t.Field(i).Type, fieldValue(v, i), segments)
+				if ok {
+					return keys, leaf, true
+				}
+			}
+		}
+	}
+
+	return nil, nil, false
+}
+
+// fieldValue returns the i-th field of the struct value v, if it is valid.
+func fieldValue(v reflect.Value, i int) reflect.Value {
+	if !v.IsValid() {
+		return v
+	}
+
+	return v.Field(i)
+}
+
+// setValue decodes node into the value at keys in v. Map entries are copied,
+// updated and stored back, so their other values are kept.
+func setValue(v reflect.Value, keys []string, node *yaml.Node) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		v = v.Elem()
+	}
+
+	if len(keys) == 0 {
+		return errors.WithStack(node.Decode(v.Addr().Interface()))
+	}
+
+	switch v.Kind() { //nolint:exhaustive
+	case reflect.Struct:
+		field, ok := yamlField(v.Type(), keys[0])
+		if !ok {
+			return errors.Errorf("unknown key %s", keys[0])
+		}
+
+		return setValue(v.FieldByIndex(field.Index), keys[1:], node)
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		key := reflect.ValueOf(keys[0]).Convert(v.Type().Key())
+
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+
+		err := setValue(elem, keys[1:], node)
+		if err != nil {
+			return err
+		}
+
+		v.SetMapIndex(key, elem)
+
+		return nil
+	default:
+		return errors.Errorf("cannot set key %s", keys[0])
+	}
+}
+
+func yamlFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "-", false
+	}
+
+	tag := field.Tag.Get("yaml")
+	name, opts, _ := strings.Cut(tag, ",")
+
+	if strings.Contains(opts, "inline") {
+		return "", true
+	}
+
+	if name == "" {
+		return strings.ToLower(field.Name), false
+	}
+
+	return name, false
+}
+
+func environOverrides(target any) error {
+	t, ok := target.(hasConfigEnvPrefix)
+	if !ok {
+		return nil
+	}
+
+	return applyEnvOverrides(t.ConfigEnvPrefix(), os.Environ(), target)
+}
@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testEnvConfig struct {
+	BasePath string `yaml:"basePath"`
+	Kinds    struct {
+		Apps struct {
+			Paths struct {
+				RequiredValues []string `yaml:"requiredValues"`
+			} `yaml:"paths"`
+		} `yaml:"apps"`
+	} `yaml:"kinds"`
+	Environments map[string]testConfigPaths `yaml:"environments"`
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	base := func() testEnvConfig {
+		c := testEnvConfig{BasePath: "base"}
+		c.Kinds.Apps.Paths.RequiredValues = []string{"values.yaml"}
+		c.Environments = map[string]testConfigPaths{
+			"development": {Required: []string{"dev"}},
+			"prod-us":     {Extends: "production", Required: []string{"prod"}, Optional: []string{"us"}},
+		}
+
+		return c
+	}
+
+	cases := map[string]struct {
+		reason  string
+		environ []string
+		want    func() testEnvConfig
+	}{
+		"TopLevelKey": {
+			reason:  "applyEnvOverrides should set keys containing underscores",
+			environ: []string{"DYTTY_BASE_PATH=other"},
+			want: func() testEnvConfig {
+				c := base()
+				c.BasePath = "other"
+
+				return c
+			},
+		},
+		"NestedKey": {
+			reason:  "applyEnvOverrides should set nested keys with YAML values",
+			environ: []string{"DYTTY_KINDS_APPS_PATHS_REQUIRED_VALUES=[a.yaml, b.yaml]"},
+			want: func() testEnvConfig {
+				c := base()
+				c.Kinds.Apps.Paths.RequiredValues = []string{"a.yaml", "b.yaml"}
+
+				return c
+			},
+		},
+		"MapKey": {
+			reason:  "applyEnvOverrides should set keys of map entries",
+			environ: []string{"DYTTY_ENVIRONMENTS_INTEGRATION_REQUIRED=int"},
+			want: func() testEnvConfig {
+				c := base()
+				c.Environments["integration"] = testConfigPaths{Required: []string{"int"}}
+
+				return c
+			},
+		},
+		"MapEntryMerged": {
+			reason:  "applyEnvOverrides should keep other values of an overridden map entry",
+			environ: []string{"DYTTY_ENVIRONMENTS_PROD_US_REQUIRED=[a, b]"},
+			want: func() testEnvConfig {
+				c := base()
+				c.Environments["prod-us"] = testConfigPaths{Extends: "production", Required: []string{"a", "b"}, Optional: []string{"us"}}
+
+				return c
+			},
+		},
+		"UnknownKeysIgnored": {
+			reason:  "applyEnvOverrides should ignore variables without a matching key",
+			environ: []string{"DYTTY_CONFIG=other.yaml", "OTHER_BASE_PATH=other"},
+			want:    base,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := base()
+
+			err := applyEnvOverrides("DYTTY", tc.environ, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want(), got); diff != "" {
+				t.Errorf("\n%s\napplyEnvOverrides(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestApplyEnvOverridesSources(t *testing.T) {
+	got := &testSourcesConfig{}
+
+	err := applyEnvOverrides("DYTTY", []string{"DYTTY_CONFIG=other.yaml", "DYTTY_BASE_PATH=other", "OTHER_BASE_PATH=other"}, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"DYTTY_BASE_PATH=other"}, got.env); diff != "" {
+		t.Errorf("applyEnvOverrides should record applied variables: -want, +got:\n%s\n", diff)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yttcmd "carvel.dev/ytt/pkg/cmd/template"
+	yttui "carvel.dev/ytt/pkg/cmd/ui"
+	"carvel.dev/ytt/pkg/orderedmap"
+	"carvel.dev/ytt/pkg/yamlmeta"
+	"gitlab.com/tozd/go/errors"
+)
+
+// DefaultDocsDir is the documentation directory used when none is configured,
+// relative to the config file.
+const DefaultDocsDir = "docs/values"
+
+type DocsCommand struct {
+	Output string `help:"Directory to write the documentation to. (default: docs/values next to the config file)" name:"output" placeholder:"PATH" yaml:"output"`
+}
+
+// ValueDoc documents a data value declared in a schema.
+type ValueDoc struct {
+	Key         string
+	Type        string
+	Default     any
+	Description string
+	// Overrides are the formatted values by environment which differ from the default.
+	Overrides map[string]string
+}
+
+// ValuesSchema returns the OpenAPI schema of the data values of the app, as
+// determined by ytt from the schema files in its inputs, or nil if there are none.
+func (app *App) ValuesSchema(cli *CLI) (*orderedmap.Map, error) {
+	opts := *yttcmd.NewOptions()
+	opts.DataValuesFlags.InspectSchema = true
+	opts.RegularFilesSourceOpts.OutputType.Types = []string{yttcmd.RegularFilesOutputTypeOpenAPI}
+	ui := yttui.NewCustomWriterTTY(false, os.Stdout, os.Stderr)
+
+	files, err := yttFiles(app, opts, cli)
+	if err != nil {
+		return nil, err
+	}
+
+	output := opts.RunWithFiles(yttcmd.Input{Files: files}, ui)
+	if output.Err != nil {
+		return nil, output.Err
+	}
+
+	doc, _ := yamlmeta.NewGoFromAST(output.DocSet.Items[0].Value).(*orderedmap.Map)
+
+	schema, _ := schemaField(doc, "components", "schemas", "dataValues").(*orderedmap.Map)
+	if _, ok := schema.Get("properties"); !ok {
+		return nil, nil
+	}
+
+	return schema, nil
+}
+
+// schemaField returns the value at the keys in nested ordered maps, or nil.
+func schemaField(m *orderedmap.Map, keys ...string) any {
+	var value any = m
+
+	for _, key := range keys {
+		nested, ok := value.(*orderedmap.Map)
+		if !ok {
+			return nil
+		}
+
+		value, _ = nested.Get(key)
+	}
+
+	return value
+}
+
+// schemaValues returns the documented data values of the OpenAPI schema in
+// order. Maps are only listed when they have a description, their values are
+// listed with dot-separated keys and values of array items with [] appended.
+func schemaValues(key string, schema *orderedmap.Map) []ValueDoc {
+	values := []ValueDoc{}
+	description, _ := schemaField(schema, "description").(string)
+
+	if deprecated, _ := schemaField(schema, "deprecated").(bool); deprecated {
+		description = strings.TrimSpace("Deprecated. " + description)
+	}
+
+	doc := ValueDoc{Key: key, Type: schemaType(schema), Description: description, Overrides: map[string]string{}}
+	doc.Default, _ = schema.Get("default")
+
+	switch doc.Type {
+	case "object":
+		if key != "" && description != "" {
+			values = append(values, doc)
+		}
+
+		properties, _ := schemaField(schema, "properties").(*orderedmap.Map)
+		properties.Iterate(func(k, v any) {
+			property, _ := v.(*orderedmap.Map)
+			values = append(values, schemaValues(strings.TrimPrefix(key+"."+fmt.Sprint(k), "."), property)...)
+		})
+	default:
+		values = append(values, doc)
+
+		if items, ok := schemaField(schema, "items").(*orderedmap.Map); ok && schemaType(items) == "object" {
+			values = append(values, schemaValues(key+"[]", items)...)
+		}
+	}
+
+	return values
+}
+
+// schemaType returns the type of the OpenAPI schema as documented.
+func schemaType(schema *orderedmap.Map) string {
+	t, _ := schemaField(schema, "type").(string)
+
+	switch {
+	case t == "":
+		return "any"
+	case t == "array":
+		if items, ok := schemaField(schema, "items").(*orderedmap.Map); ok {
+			t = "array of " + schemaType(items)
+		}
+	case t == "number" && schemaField(schema, "format") == "float":
+		t = "float"
+	}
+
+	if nullable, _ := schemaField(schema, "nullable").(bool); nullable {
+		t += ", nullable"
+	}
+
+	return t
+}
+
+// formatValue returns the value as compact JSON.
+func formatValue(value any) string {
+	data, err := marshalJSON(value, false)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+// markdownCell escapes s for use in a Markdown table cell.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", "<br>").Replace(s)
+}
+
+// AppDocs returns the Markdown documentation of the data values of the app,
+// with the values set differently from their default in each of its
+// environments, or an empty string if it has no schema.
+func (cli *CLI) AppDocs(listed ListedApp) (string, errors.E) {
+	var values []ValueDoc
+
+	var redactor *Redactor
+
+	for _, env := range listed.Environments {
+		app, errE := NewApp(listed.Kind, listed.Name, env, cli)
+		if errE != nil {
+			return "", errE
+		}
+
+		redactor = cli.NewRedactor(app)
+
+		if values == nil {
+			schema, err := app.ValuesSchema(cli)
+			if err != nil {
+				return "", errors.WithDetails(errors.WithStack(err), "env", env)
+			} else if schema == nil {
+				return "", nil
+			}
+
+			values = schemaValues("", schema)
+		}
+
+		envValues, err := ParseValues(app, cli)
+		if err != nil {
+			return "", errors.WithDetails(errors.WithStack(err), "env", env)
+		}
+
+		envValues = redactor.Values(envValues)
+
+		for _, doc := range values {
+			// The image tag is set by dytty, not in values files.
+			if doc.Key == ImageTagValue || strings.Contains(doc.Key, "[]") {
+				continue
+			}
+
+			value, errE := dataValue(envValues, doc.Key)
+			if errE != nil {
+				continue
+			}
+
+			if formatted := formatValue(value); formatted != formatValue(redactor.value(doc.Key, doc.Default)) {
+				doc.Overrides[env] = formatted
+			}
+		}
+	}
+
+	if values == nil {
+		return "", nil
+	}
+
+	var buf strings.Builder
+
+	_, _ = fmt.Fprintf(&buf, "# %s/%s\n\n", listed.Kind, listed.Name)
+	_, _ = fmt.Fprintf(&buf, "Data values of %s declared by its data values schema. Generated by `dytty docs`, do not edit.\n\n", listed.Name)
+	_, _ = fmt.Fprintln(&buf, "| Value | Type | Default | Description |")
+	_, _ = fmt.Fprintln(&buf, "| --- | --- | --- | --- |")
+
+	overridden := false
+
+	for _, doc := range values {
+		def := ""
+		if doc.Type != "object" {
+			def = "`" + markdownCell(formatValue(redactor.value(doc.Key, doc.Default))) + "`"
+		}
+
+		_, _ = fmt.Fprintf(&buf, "| `%s` | %s | %s | %s |\n", doc.Key, doc.Type, def, markdownCell(doc.Description))
+
+		overridden = overridden || len(doc.Overrides) > 0
+	}
+
+	if !overridden {
+		return buf.String(), nil
+	}
+
+	_, _ = fmt.Fprintf(&buf, "\n## Environment overrides\n\nValues set differently from their default by environment.\n\n")
+	_, _ = fmt.Fprintf(&buf, "| Value | %s |\n", strings.Join(listed.Environments, " | "))
+	_, _ = fmt.Fprintf(&buf, "| --- |%s\n", strings.Repeat(" --- |", len(listed.Environments)))
+
+	for _, doc := range values {
+		if len(doc.Overrides) == 0 {
+			continue
+		}
+
+		_, _ = fmt.Fprintf(&buf, "| `%s` |", doc.Key)
+
+		for _, env := range listed.Environments {
+			if value, ok := doc.Overrides[env]; ok {
+				_, _ = fmt.Fprintf(&buf, " `%s` |", markdownCell(value))
+			} else {
+				_, _ = fmt.Fprint(&buf, " |")
+			}
+		}
+
+		_, _ = fmt.Fprintln(&buf)
+	}
+
+	return buf.String(), nil
+}
+
+func (c *DocsCommand) docsDir(cli *CLI) (string, errors.E) {
+	if c.Output != "" {
+		return c.Output, nil
+	}
+
+	return cli.ConfigRelative(DefaultDocsDir)
+}
+
+func (c *DocsCommand) Run(cli *CLI) errors.E {
+	logger := cli.GetLoggingConfig().Logger
+
+	dir, errE := c.docsDir(cli)
+	if errE != nil {
+		return errE
+	}
+
+	logger.Info().Msgf("Generating docs in: %s", dir)
+
+	// Docs never contain secrets, so they are generated without decrypting any.
+	cli.NoSecrets = true
+
+	apps, errE := cli.ListApps()
+	if errE != nil {
+		return errE
+	}
+
+	failed := 0
+
+	for _, listed := range apps {
+		if len(listed.Environments) == 0 {
+			continue
+		}
+
+		page, errE := cli.AppDocs(listed)
+		if errE != nil {
+			failed++
+
+			logger.Error().Msgf("Cannot generate docs for kind: %s, app: %s: %s", listed.Kind, listed.Name, errE)
+
+			continue
+		} else if page == "" {
+			logger.Info().Msgf("No data values schema for kind: %s, app: %s", listed.Kind, listed.Name)
+
+			continue
+		}
+
+		path := filepath.Join(dir, listed.Kind, listed.Name+".md")
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755) //nolint:gomnd
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = os.WriteFile(path, []byte(page), 0o644) //nolint:gomnd,gosec
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, _ = fmt.Fprintf(os.Stdout, "%s\n", path)
+	}
+
+	if failed > 0 {
+		return errors.Errorf("docs of %d apps cannot be generated", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAppDocs(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"schema.yaml": `#@data/values-schema
+---
+templates: [""]
+app:
+  image:
+    tag: ""
+  #@schema/desc "Number of pod replicas."
+  replicas: 1
+  #@schema/desc "Database password."
+  password: ""
+  #@schema/nullable
+  #@schema/desc "Ingress host | domain."
+  host: ""
+  #@schema/desc "Extra ports."
+  ports:
+  - name: ""
+    port: 0
+`,
+		"global/values.yaml":              "#@data/values\n---\ntemplates: []\n",
+		"envs/production/values.yaml":     "#@data/values\n---\napp:\n  replicas: 3\n  password: hunter2\n",
+		"apps/example/" + AppMetadataFile: "environments: [development, production]\n",
+		"apps/plain/" + AppMetadataFile:   "environments: [development]\n",
+	})
+
+	want := "# apps/example\n" +
+		"\n" +
+		"Data values of example declared by its data values schema. Generated by `dytty docs`, do not edit.\n" +
+		"\n" +
+		"| Value | Type | Default | Description |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| `templates` | array of string | `[]` |  |\n" +
+		"| `app.image.tag` | string | `\"\"` |  |\n" +
+		"| `app.replicas` | integer | `1` | Number of pod replicas. |\n" +
+		"| `app.password` | string | `\"<redacted>\"` | Database password. |\n" +
+		"| `app.host` | string, nullable | `null` | Ingress host \\| domain. |\n" +
+		"| `app.ports` | array of object | `[]` | Extra ports. |\n" +
+		"| `app.ports[].name` | string | `\"\"` |  |\n" +
+		"| `app.ports[].port` | integer | `0` |  |\n" +
+		"\n" +
+		"## Environment overrides\n" +
+		"\n" +
+		"Values set differently from their default by environment.\n" +
+		"\n" +
+		"| Value | development | production |\n" +
+		"| --- | --- | --- |\n" +
+		"| `app.replicas` | | `3` |\n"
+
+	cases := map[string]struct {
+		reason string
+		schema string
+		app    string
+		want   string
+	}{
+		"Schema": {
+			reason: "AppDocs should document schema values with their environment overrides",
+			schema: basePath + "/schema.yaml",
+			app:    "example",
+			want:   want,
+		},
+		"NoSchema": {
+			reason: "AppDocs should return no docs for apps without a schema",
+			app:    "plain",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cli := &CLI{BasePath: basePath, NoSecrets: true}
+			cli.Kinds.Apps.Schema = tc.schema
+			cli.Environments = map[string]Environment{
+				"development": {},
+				"production":  {Paths: EnvPaths{RequiredValues: []string{basePath + "/envs/{{.Env.Name}}/values.yaml"}}},
+			}
+
+			apps, errE := cli.ListApps()
+			if errE != nil {
+				t.Fatal(errE)
+			}
+
+			for _, listed := range apps {
+				if listed.Name != tc.app {
+					continue
+				}
+
+				got, errE := cli.AppDocs(listed)
+				if errE != nil {
+					t.Fatal(errE)
+				}
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Errorf("\n%s\nAppDocs(...): -want, +got:\n%s\n", tc.reason, diff)
+				}
+			}
+		})
+	}
+}
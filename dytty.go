@@ -2,18 +2,20 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
-	"text/template"
 
 	yttcmd "carvel.dev/ytt/pkg/cmd/template"
 	yttui "carvel.dev/ytt/pkg/cmd/ui"
 	yttfiles "carvel.dev/ytt/pkg/files"
+	"carvel.dev/ytt/pkg/yamlmeta"
 	"github.com/alecthomas/kong"
+	"github.com/creasty/defaults"
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/zerolog"
 	yaml "gopkg.in/yaml.v3"
@@ -21,11 +23,14 @@ import (
 	"github.com/blakebarnett/dytty/cli"
 )
 
+// ImageTagValue is the data value set to the image tag of the app.
+const ImageTagValue = "app.image.tag"
+
 type CLIGlobals struct {
 	zerolog.LoggingConfig `yaml:",inline"`
 
 	Version kong.VersionFlag `help:"Show program's version and exit."                                              short:"V" yaml:"-"`
-	Config  cli.ConfigFlag   `help:"Load configuration from a JSON or YAML file. (default: .dytty.yaml)" name:"config" placeholder:"PATH" short:"c" yaml:"-" default:".dytty.yaml"`
+	Config  cli.ConfigFlag   `help:"Load configuration from a JSON or YAML file. (default: .dytty.yaml)" name:"config" placeholder:"PATH" short:"c" yaml:"-" default:".dytty.yaml" env:"DYTTY_CONFIG"`
 }
 
 type CLI struct {
@@ -40,7 +45,13 @@ type CLI struct {
 	} `cmd:"" yaml:"global" hidden:"true"`
 	Kinds struct {
 		Apps struct {
-			Paths struct {
+			Vars       map[string]string `name:"vars" yaml:"vars"`
+			Metadata   string            `name:"metadata" yaml:"metadata"`
+			Schema     string            `name:"schema" yaml:"schema"`
+			PostRender []PostRender      `yaml:"postRender" kong:"-"`
+			Secrets    SecretsConfig     `yaml:"secrets" kong:"-"`
+			Labels     StandardLabels    `yaml:"standardLabels" kong:"-"`
+			Paths      struct {
 				Required       []string `name:"required" yaml:"required"`
 				RequiredValues []string `name:"required-values" yaml:"requiredValues"`
 				Optional       []string `name:"optional" yaml:"optional"`
@@ -49,25 +60,91 @@ type CLI struct {
 		} `cmd:"" yaml:"apps" hidden:"true"`
 	} `cmd:"" yaml:"kinds" hidden:"true"`
 	Environments map[string]Environment `name:"environments" yaml:"environments" hidden:"true"`
-	BasePath     string                 `help:"Base path for the application." name:"base-path" placeholder:"PATH" short:"b" yaml:"basePath"`
-	ImageTag     string                 `help:"The image tag to use for the application." name:"image-tag" placeholder:"TAG" short:"t" yaml:"imageTag"`
+	Clusters     map[string]Cluster     `name:"clusters" yaml:"clusters" hidden:"true"`
+	BasePath     string                 `help:"Base path for the application." name:"base-path" placeholder:"PATH" short:"b" yaml:"basePath" env:"DYTTY_BASE_PATH"`
+	ImageTag     string                 `help:"The image tag to use for the application." name:"image-tag" placeholder:"TAG" short:"t" yaml:"imageTag" env:"DYTTY_IMAGE_TAG"`
+	Cluster      string                 `help:"The cluster (or region) to render the application for." name:"cluster" placeholder:"NAME" yaml:"cluster" env:"DYTTY_CLUSTER"`
+	NoSecrets    bool                   `help:"Use placeholders instead of decrypting SOPS encrypted values files." name:"no-secrets" yaml:"noSecrets" env:"DYTTY_NO_SECRETS"`
+	UpdateSealed bool                   `help:"Record values sealed with kubeseal in the lock file. Otherwise they are sealed only for this run." name:"update-sealed" yaml:"updateSealed" env:"DYTTY_UPDATE_SEALED"`
+	Redact       Redaction              `yaml:"redact" kong:"-"`
+	Policies     string                 `help:"Directory with policy files evaluated against rendered manifests." name:"policies" placeholder:"PATH" yaml:"policies" env:"DYTTY_POLICIES"`
 	Render       RenderCommand          `cmd:"" help:"Render manifests for an application." yaml:"render"`
 	Values       ValuesCommand          `cmd:"" help:"Render data values for an application." yaml:"values"`
 	Files        FilesCommand           `cmd:"" help:"Inspect all files involved for rendering an application." yaml:"files"`
+	List         ListCommand            `cmd:"" help:"List applications, kinds or environments." yaml:"list"`
+	Affected     AffectedCommand        `cmd:"" help:"List application targets affected by changes since a git revision." yaml:"affected"`
+	Test         TestCommand            `cmd:"" help:"Compare rendered manifests of all applications with their snapshots." yaml:"test"`
+	Vendor       VendorCommand          `cmd:"" help:"Sync shared ytt libraries into the vendor directory." yaml:"vendor"`
+	Docs         DocsCommand            `cmd:"" help:"Generate Markdown documentation of app data values from their schemas." yaml:"docs"`
+	Verify       VerifyCommand          `cmd:"" help:"Re-render the target of a render provenance manifest and compare the output." yaml:"verify"`
+
+	// configFiles and configEnv are the config files and the environment
+	// variable overrides the configuration was loaded from, in order.
+	configFiles []InputFile
+	configEnv   []string
+	// snapshot is set when rendering snapshots, which leave out values changing
+	// with every commit.
+	snapshot bool
+}
+
+// ConfigPathKeys returns the configuration keys holding paths, which are resolved
+// relative to the configuration file they are defined in.
+func (c *CLI) ConfigPathKeys() []string {
+	return []string{"basePath", "required", "requiredValues", "optional", "metadata", "snapshots", "policies", "overlay", "patch", "directory", "gitBundle", "tarball", "cert", "schema", "output", "phaseDir", "provenance"}
+}
+
+// FindConfig returns the location of the config file in use.
+func (c *CLI) FindConfig() (string, error) {
+	return cli.FindConfig(string(c.Config))
+}
+
+// ConfigRelative returns path joined to the directory of the config file in
+// use, or path as is when there is no config file.
+func (c *CLI) ConfigRelative(path string) (string, errors.E) {
+	if c.Config == "" {
+		return path, nil
+	}
+
+	config, err := c.FindConfig()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return filepath.Join(filepath.Dir(config), path), nil
+}
+
+// ConfigEnvPrefix returns the prefix of environment variables overriding
+// configuration keys, e.g. DYTTY_KINDS_APPS_PATHS_REQUIRED_VALUES.
+func (c *CLI) ConfigEnvPrefix() string {
+	return "DYTTY"
 }
 
 type BaseApp struct {
-	Name  string
-	Env   Environment
-	Kind  string
-	Paths Paths
+	Name    string
+	Env     Environment
+	Cluster Cluster
+	Kind    string
+	Vars    map[string]string
+	Paths   Paths
 }
 
 type App struct {
 	BaseApp
-	Kind  string `default:"apps" yaml:"kind"`
-	Paths AppsPaths
-	Image AppImage
+	Kind       string `default:"apps" yaml:"kind"`
+	Paths      AppsPaths
+	Image      AppImage
+	PostRender []PostRender
+	Schema     []string
+	Metadata   AppMetadata `yaml:"-"`
+	// DecryptedValues are the values decrypted from encrypted files for the last render.
+	DecryptedValues []string `yaml:"-"`
+	// InputFiles are the ytt input files of the last render, before decryption.
+	InputFiles []*yttfiles.File `yaml:"-"`
+	// PathPatterns are the rendered path templates the paths were matched from.
+	PathPatterns []string `yaml:"-"`
+	// Decrypted caches the decrypted content of encrypted files by their
+	// encrypted content, as one render runs ytt multiple times.
+	Decrypted map[string][]byte `yaml:"-"`
 }
 
 type Serverless struct {
@@ -96,8 +173,22 @@ type EnvPaths struct {
 }
 
 type Environment struct {
+	Name       string
+	Alias      string `yaml:"-"`
+	Extends    string `yaml:"extends"`
+	Paths      EnvPaths
+	PostRender []PostRender `yaml:"postRender"`
+}
+
+type ClusterPaths struct {
+	Required       []string `name:"required" yaml:"required"`
+	RequiredValues []string `name:"required-values" yaml:"requiredValues"`
+	Optional       []string `name:"optional" yaml:"optional"`
+}
+
+type Cluster struct {
 	Name  string
-	Paths EnvPaths
+	Paths ClusterPaths
 }
 
 type AppImage struct {
@@ -121,21 +212,64 @@ type ServerlessPaths struct {
 }
 
 type RenderCommand struct {
-	Kind string `arg:"" help:"The application kind." name:"kind" enum:"apps,lambda,infra" yaml:"kind"`
-	App  string `arg:"" help:"The application name." name:"app" yaml:"app"`
-	Env  string `arg:"" help:"The environment name." name:"env" enum:"dev,development,int,integration,prd,prod,production" yaml:"env"`
+	Kind       string  `arg:"" help:"The application kind." name:"kind" enum:"apps,lambda,infra" yaml:"kind"`
+	App        string  `arg:"" help:"The application name." name:"app" yaml:"app"`
+	Env        string  `arg:"" help:"The environment name." name:"env" yaml:"env"`
+	Enforce    bool    `help:"Fail if rendered manifests violate policies with error severity." name:"enforce" yaml:"enforce"`
+	Format     string  `help:"Output format, one of yaml, json, jsonl or list (a v1 List in JSON)." name:"format" enum:"yaml,json,jsonl,list" default:"yaml" yaml:"format"`
+	Sort       string  `help:"Sort documents into apply phases, one of none, kapp (Namespaces and CustomResourceDefinitions first, webhooks last) or config (the configured render.phases)." name:"sort" enum:"none,kapp,config" default:"none" yaml:"sort"`
+	Phases     []Phase `yaml:"phases" kong:"-"`
+	PhaseDir   string  `help:"Write the documents of each apply phase to a numbered file in this directory instead of printing them." name:"phase-dir" placeholder:"PATH" yaml:"phaseDir"`
+	SortKinds  bool    `help:"Deprecated, use --sort kapp." name:"sort-kinds" yaml:"sortKinds"`
+	Provenance string  `help:"Write a provenance manifest recording the inputs of the render to this path, e.g. render-manifest.json." name:"provenance" placeholder:"PATH" yaml:"provenance"`
+}
+
+// sortStrategy returns the sort strategy in use. The deprecated --sort-kinds is
+// an alias of --sort kapp.
+func (c *RenderCommand) sortStrategy() string {
+	if (c.Sort == "" || c.Sort == SortNone) && c.SortKinds {
+		return SortKapp
+	}
+
+	return c.Sort
+}
+
+// phases returns the apply phases of the sort strategy, or nil if documents are
+// not sorted.
+func (c *RenderCommand) phases() []Phase {
+	switch c.sortStrategy() {
+	case SortKapp:
+		return KappPhases
+	case SortConfig:
+		return c.Phases
+	default:
+		return nil
+	}
+}
+
+// output sorts the rendered documents by the sort strategy and returns them in
+// the output format.
+func (c *RenderCommand) output(docSet *yamlmeta.DocumentSet) ([]byte, errors.E) {
+	if phases := c.phases(); phases != nil {
+		errE := SortDocuments(docSet, phases)
+		if errE != nil {
+			return nil, errE
+		}
+	}
+
+	return FormatDocuments(docSet, c.Format)
 }
 
 type ValuesCommand struct {
 	Kind string `arg:"" help:"The application kind." name:"kind" enum:"apps,lambda,infra" yaml:"kind"`
 	App  string `arg:"" help:"The application name." name:"app" yaml:"app"`
-	Env  string `arg:"" help:"The environment name." name:"env" enum:"dev,development,int,integration,prd,prod,production" yaml:"env"`
+	Env  string `arg:"" help:"The environment name." name:"env" yaml:"env"`
 }
 
 type FilesCommand struct {
 	Kind string `arg:"" help:"The application kind." name:"kind" enum:"apps,lambda,infra" yaml:"kind"`
 	App  string `arg:"" help:"The application name." name:"app" yaml:"app"`
-	Env  string `arg:"" help:"The environment name." name:"env" enum:"dev,development,int,integration,prd,prod,production" yaml:"env"`
+	Env  string `arg:"" help:"The environment name." name:"env" yaml:"env"`
 }
 
 func NewEnv(name string, cli *CLI) *Environment {
@@ -143,39 +277,132 @@ func NewEnv(name string, cli *CLI) *Environment {
 	logger := cli.GetLoggingConfig().Logger
 	logger.Debug().Msgf("Creating new env: %s", name)
 
-	nn, err := NormalizeEnvName(name)
+	nn, err := cli.EnvName(name)
 	if err != nil {
 		panic(err)
 	}
 
 	env.Name = nn
+	env.Alias = name
+	env.Extends = cli.Environments[nn].Extends
 	logger.Debug().Msgf("Env: %v", env)
 
 	return env
 }
 
-func NewApp(kind string, name string, env string, cli *CLI) *App {
+// EnvName returns the name of the environment given by its name or alias.
+// Environments defined in the config are used by their name.
+func (cli *CLI) EnvName(name string) (string, error) {
+	nn, err := NormalizeEnvName(name)
+	if err != nil {
+		if _, ok := cli.Environments[name]; !ok {
+			return "", err
+		}
+
+		nn = name
+	}
+
+	return nn, nil
+}
+
+// Validate checks the environment argument of the selected command. It cannot
+// be an enum, as environments can also be defined in the config.
+func (c *CLI) Validate() error {
+	for _, env := range []string{c.Render.Env, c.Values.Env, c.Files.Env} {
+		if env == "" {
+			continue
+		}
+
+		if _, err := c.EnvName(env); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func NewCluster(name string, cli *CLI) (*Cluster, errors.E) {
+	cluster := &Cluster{}
+	logger := cli.GetLoggingConfig().Logger
+	logger.Debug().Msgf("Creating new cluster: %s", name)
+
+	if _, ok := cli.Clusters[name]; !ok && name != "" {
+		return nil, errors.WithDetails(errors.Errorf("invalid cluster name: %s", name), "cluster", name)
+	}
+
+	cluster.Name = name
+	logger.Debug().Msgf("Cluster: %v", cluster)
+
+	return cluster, nil
+}
+
+func NewApp(kind string, name string, env string, cli *CLI) (*App, errors.E) {
 	logger := cli.GetLoggingConfig().Logger
 	logger.Debug().Msgf("Creating new app: %s, %s, %s", kind, name, env)
+
+	cluster, errE := NewCluster(cli.Cluster, cli)
+	if errE != nil {
+		return nil, errors.WithDetails(errE, "kind", kind, "app", name, "env", env)
+	}
+
 	app := &App{
 		BaseApp: BaseApp{
-			Name: name,
-			Env:  *NewEnv(env, cli),
-			Kind: kind,
+			Name:    name,
+			Env:     *NewEnv(env, cli),
+			Cluster: *cluster,
+			Kind:    kind,
+			Vars:    cli.Kinds.Apps.Vars,
 		},
 	}
 
+	err := defaults.Set(app)
+	if err != nil {
+		panic(err)
+	}
+
+	errE = app.loadMetadata(cli)
+	if errE != nil {
+		return nil, errors.WithDetails(errE, "kind", kind, "app", name, "env", env)
+	}
+
+	// Check before setting paths, as those for a disabled environment usually do not exist.
+	if !app.EnvEnabled() {
+		return nil, errors.WithDetails(
+			errors.Errorf("app %s is not enabled in environment %s", app.Name, app.Env.Name),
+			"enabled", app.Metadata.Environments,
+		)
+	}
+
 	if cli.ImageTag != "" {
 		app.Image.Tag = cli.ImageTag
 	}
 
-	app.SetPaths(cli)
+	errE = app.SetPaths(cli)
+	if errE != nil {
+		return nil, errors.WithDetails(errE, "kind", kind, "app", name, "env", env)
+	}
 
 	buf := io.Writer(bytes.NewBuffer([]byte{}))
 
 	logger.Debug().Msgf("App: %s", yaml.NewEncoder(buf).Encode(app))
 
-	return app
+	return app, nil
+}
+
+// EnvEnabled returns true if the app is enabled in its environment. Apps which
+// do not declare their environments are enabled in all of them.
+func (app *App) EnvEnabled() bool {
+	if len(app.Metadata.Environments) == 0 {
+		return true
+	}
+
+	for _, e := range app.Metadata.Environments {
+		if e == app.Env.Name {
+			return true
+		}
+	}
+
+	return false
 }
 
 func NormalizeEnvName(e string) (string, error) {
@@ -195,7 +422,35 @@ func NormalizeEnvName(e string) (string, error) {
 	return "", err
 }
 
-func ValidatePaths(required bool, paths []string) []string {
+// EnvLineage returns the name of the environment preceded by the names of the
+// environments it extends, starting with the root of the inheritance chain.
+func (cli *CLI) EnvLineage(name string) ([]string, error) {
+	lineage := []string{}
+	seen := map[string]bool{}
+
+	for n := name; n != ""; n = cli.Environments[n].Extends {
+		if nn, err := NormalizeEnvName(n); err == nil {
+			n = nn
+		}
+
+		if seen[n] {
+			return nil, fmt.Errorf("environment inheritance cycle: %s extends %s", lineage[0], n)
+		}
+
+		if _, ok := cli.Environments[n]; !ok && n != name {
+			return nil, fmt.Errorf("environment %s extends unknown environment: %s", lineage[0], n)
+		}
+
+		seen[n] = true
+		lineage = append([]string{n}, lineage...)
+	}
+
+	return lineage, nil
+}
+
+// ValidatePaths returns the files matching the glob patterns in paths.
+// Required paths without any matching files are an error.
+func ValidatePaths(required bool, paths []string) ([]string, errors.E) {
 	files := []string{}
 
 	for _, path := range paths {
@@ -204,60 +459,151 @@ func ValidatePaths(required bool, paths []string) []string {
 		}
 
 		matches, err := filepath.Glob(path)
-		if err != nil && errors.Is(err, os.ErrNotExist) {
-			if required {
-				panic(err)
-			}
-		} else if matches == nil {
-			if required {
-				panic(fmt.Errorf("no files found for required path: %s", (path)))
-			}
+		if err != nil {
+			return nil, errors.WithDetails(errors.WithStack(err), "path", path)
+		} else if matches == nil && required {
+			return nil, errors.Errorf("no files found for required path: %s", path)
 		}
 
 		files = append(files, matches...)
 	}
 
-	return files
+	return files, nil
 }
 
-func (app *App) renderPathTemplates(templates []string) []string {
+func (app *App) renderPathTemplates(templates []string) ([]string, errors.E) {
 	results := []string{}
 
 	for _, ts := range templates {
-		t, err := template.New("app").Parse(ts)
+		result, err := renderPathTemplate(ts, app.templateData())
 		if err != nil {
-			panic(err)
+			return nil, errors.WithDetails(errors.WithStack(err), "template", ts)
 		}
 
-		var buf bytes.Buffer
+		results = append(results, result)
+	}
 
-		err = t.Execute(&buf, app)
-		if err != nil {
-			panic(err)
-		}
+	return results, nil
+}
+
+// pathLayer is a layer of path templates whose matching files are appended to paths.
+type pathLayer struct {
+	paths     *[]string
+	required  bool
+	templates []string
+}
+
+// appendPaths appends the files matching the rendered path templates to paths.
+func (app *App) appendPaths(paths *[]string, required bool, templates []string) errors.E {
+	rendered, errE := app.renderPathTemplates(templates)
+	if errE != nil {
+		return errE
+	}
 
-		results = append(results, buf.String())
+	files, errE := ValidatePaths(required, rendered)
+	if errE != nil {
+		return errE
+	}
+
+	*paths = append(*paths, files...)
+
+	for _, pattern := range rendered {
+		if pattern != "" {
+			app.PathPatterns = append(app.PathPatterns, pattern)
+		}
 	}
 
-	return results
+	return nil
 }
 
-func (app *App) SetPaths(cli *CLI) *App {
-	// The first two will panic if the paths do not exist as they set required to true
-	app.Paths.Required = ValidatePaths(true, app.renderPathTemplates(cli.Kinds.Apps.Paths.Required))
-	app.Paths.RequiredValues = ValidatePaths(true, app.renderPathTemplates(cli.Kinds.Apps.Paths.RequiredValues))
-	app.Paths.Optional = ValidatePaths(false, app.renderPathTemplates(cli.Kinds.Apps.Paths.Optional))
+// SetPaths sets the paths of the app from the configured path templates. Paths
+// of required layers which do not match any files are an error.
+func (app *App) SetPaths(cli *CLI) errors.E {
+	app.Schema = nil
+	app.Paths.Required = []string{}
+	app.Paths.RequiredValues = []string{}
+	app.Paths.Optional = []string{}
+	app.Env.Paths.Required = []string{}
+	app.Env.Paths.RequiredValues = []string{}
+	app.PathPatterns = []string{}
+
+	var errE errors.E
+
+	app.PostRender, errE = app.renderPostRender(cli.Kinds.Apps.PostRender)
+	if errE != nil {
+		return errE
+	}
+
+	layers := []pathLayer{
+		// The schema of the kind comes first, so it types and defaults all data values.
+		{&app.Schema, true, []string{cli.Kinds.Apps.Schema}},
+		{&app.Paths.Required, true, cli.Kinds.Apps.Paths.Required},
+		{&app.Paths.RequiredValues, true, cli.Kinds.Apps.Paths.RequiredValues},
+		{&app.Paths.Optional, false, cli.Kinds.Apps.Paths.Optional},
+		// Extra path layers from the app metadata file come after the kind paths.
+		{&app.Paths.Required, true, app.Metadata.Paths.Required},
+		{&app.Paths.RequiredValues, true, app.Metadata.Paths.RequiredValues},
+		{&app.Paths.Optional, false, app.Metadata.Paths.Optional},
+	}
+
+	if app.Cluster.Name != "" {
+		cluster := cli.Clusters[app.Cluster.Name]
+		app.Cluster.Paths = ClusterPaths{Required: []string{}, RequiredValues: []string{}, Optional: []string{}}
+		layers = append(layers, []pathLayer{
+			{&app.Cluster.Paths.Required, true, cluster.Paths.Required},
+			{&app.Cluster.Paths.RequiredValues, true, cluster.Paths.RequiredValues},
+			{&app.Cluster.Paths.Optional, false, cluster.Paths.Optional},
+		}...)
+	}
+
+	for _, layer := range layers {
+		errE := app.appendPaths(layer.paths, layer.required, layer.templates)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	lineage, err := cli.EnvLineage(app.Env.Name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Paths of extended environments come first, rendered with their own name.
+	for _, name := range lineage {
+		layer := *app
+		layer.Env.Name = name
+		layer.Env.Alias = name
+
+		errE := layer.appendPaths(&app.Env.Paths.Required, true, cli.Environments[name].Paths.Required)
+		if errE != nil {
+			return errE
+		}
+
+		errE = layer.appendPaths(&app.Env.Paths.RequiredValues, true, cli.Environments[name].Paths.RequiredValues)
+		if errE != nil {
+			return errE
+		}
+
+		app.PathPatterns = layer.PathPatterns
 
-	app.Env.Paths.Required = ValidatePaths(true, app.renderPathTemplates(cli.Environments[app.Env.Name].Paths.Required))
-	app.Env.Paths.RequiredValues = ValidatePaths(true, app.renderPathTemplates(cli.Environments[app.Env.Name].Paths.RequiredValues))
+		postRender, errE := layer.renderPostRender(cli.Environments[name].PostRender)
+		if errE != nil {
+			return errE
+		}
+
+		app.PostRender = append(app.PostRender, postRender...)
+	}
 
-	return app
+	return nil
 }
 
 func (c *FilesCommand) Run(cli *CLI) errors.E {
 	logger := cli.GetLoggingConfig().Logger
-	logger.Info().Msgf("Files for kind: %s, app: %s, env: %s", c.Kind, c.App, c.Env)
-	app := NewApp(c.Kind, c.App, c.Env, cli)
+	logger.Info().Msgf("Files for kind: %s, app: %s, env: %s, cluster: %s", c.Kind, c.App, c.Env, cli.Cluster)
+	app, errE := NewApp(c.Kind, c.App, c.Env, cli)
+	if errE != nil {
+		return errE
+	}
 
 	data, err := ytt(app, false, true, cli)
 	if err != nil {
@@ -282,7 +628,7 @@ func ParseValues(app *App, cli *CLI) (map[string]any, error) {
 
 	data, err := ytt(app, true, false, cli)
 	if err != nil {
-		return nil, errors.Errorf("ParseValues() error: %v", err)
+		return nil, errors.Errorf("ParseValues() error: %s", cli.NewRedactor(app).String(err.Error()))
 	}
 
 	err = yaml.Unmarshal(data, &results)
@@ -290,47 +636,44 @@ func ParseValues(app *App, cli *CLI) (map[string]any, error) {
 		log.Fatalf("ParseValues(): %v", err)
 	}
 
-	logger.Debug().Msgf("RESULTS ParseValues: %s", results)
+	logger.Debug().Msgf("RESULTS ParseValues: %s", cli.NewRedactor(app).Values(results))
 
 	return results, nil
 }
 
 func (c *ValuesCommand) Run(cli *CLI) errors.E {
 	logger := cli.GetLoggingConfig().Logger
-	logger.Info().Msgf("Values for kind: %s, app: %s, env: %s", c.Kind, c.App, c.Env)
-	app := NewApp(c.Kind, c.App, c.Env, cli)
+	logger.Info().Msgf("Values for kind: %s, app: %s, env: %s, cluster: %s", c.Kind, c.App, c.Env, cli.Cluster)
+	app, errE := NewApp(c.Kind, c.App, c.Env, cli)
+	if errE != nil {
+		return errE
+	}
 
 	results, err := ParseValues(app, cli)
 	if err != nil {
 		panic(err)
 	}
 
-	_, _ = fmt.Fprintf(os.Stdout, "%s", results)
+	_, _ = fmt.Fprintf(os.Stdout, "%s", cli.NewRedactor(app).Values(results))
 
 	return nil
 }
 
-func (c *RenderCommand) Run(cli *CLI) errors.E { //nolint:unparam
+// SetTemplatePaths renders the data values of the app and sets its template
+// paths from the templates listed in them.
+func (app *App) SetTemplatePaths(cli *CLI) error {
 	logger := cli.GetLoggingConfig().Logger
-	logger.Info().Msgf("Rendering for kind: %s, app: %s, env: %s", c.Kind, c.App, c.Env)
-	app := NewApp(c.Kind, c.App, c.Env, cli)
-
-	logger.Info().Msgf("Required paths: %s", app.Paths.Required)
-	logger.Info().Msgf("Required Data Values paths: %s", app.Paths.RequiredValues)
-	logger.Info().Msgf("Env Required paths: %s", app.Env.Paths.Required)
-	logger.Info().Msgf("Env Data Values paths: %s", app.Env.Paths.RequiredValues)
-	logger.Info().Msgf("Optional paths: %s", app.Paths.Optional)
 
 	// Render the data values
 	data, err := ytt(app, true, false, cli)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	err = yaml.Unmarshal(data, &app.Paths)
 	if err != nil {
 		logger.Error().Msgf("Error unmarshalling data: %s", err)
-		panic(err)
+		return err
 	}
 
 	// Validate the templates exist also
@@ -339,66 +682,321 @@ func (c *RenderCommand) Run(cli *CLI) errors.E { //nolint:unparam
 		templates = append(templates, cli.BasePath+"/templates/"+t)
 	}
 
-	app.Paths.Templates = ValidatePaths(true, templates)
-	logger.Info().Msgf("Template paths: %s", app.Paths.Templates)
+	app.Paths.Templates, err = ValidatePaths(true, templates)
+
+	return err
+}
 
-	results, err := ytt(app, false, false, cli)
+func (c *RenderCommand) Run(cli *CLI) errors.E {
+	logger := cli.GetLoggingConfig().Logger
+	logger.Info().Msgf("Rendering for kind: %s, app: %s, env: %s, cluster: %s", c.Kind, c.App, c.Env, cli.Cluster)
+	app, errE := NewApp(c.Kind, c.App, c.Env, cli)
+	if errE != nil {
+		return errE
+	}
+
+	logger.Info().Msgf("Required paths: %s", app.Paths.Required)
+	logger.Info().Msgf("Required Data Values paths: %s", app.Paths.RequiredValues)
+	logger.Info().Msgf("Env Required paths: %s", app.Env.Paths.Required)
+	logger.Info().Msgf("Env Data Values paths: %s", app.Env.Paths.RequiredValues)
+	logger.Info().Msgf("Cluster Required paths: %s", app.Cluster.Paths.Required)
+	logger.Info().Msgf("Cluster Data Values paths: %s", app.Cluster.Paths.RequiredValues)
+	logger.Info().Msgf("Cluster Optional paths: %s", app.Cluster.Paths.Optional)
+	logger.Info().Msgf("Optional paths: %s", app.Paths.Optional)
+
+	docSet, err := app.RenderDocuments(cli)
 	if err != nil {
-		panic(err)
+		panic(errors.New(cli.NewRedactor(app).String(err.Error())))
+	}
+
+	results, err := docSet.AsBytes()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	phases := c.phases()
+	if phases == nil && c.PhaseDir != "" {
+		return errors.New("--phase-dir requires --sort")
+	}
+
+	if cli.Policies != "" {
+		redactor := cli.NewRedactor(app)
+
+		violations, errE := cli.CheckPolicies(app, results)
+		if errE != nil {
+			return errE
+		}
+
+		failed := 0
+
+		for _, violation := range violations {
+			if violation.Severity == SeverityError {
+				failed++
+
+				logger.Error().Msgf("Policy violation: %s", redactor.String(violation.String()))
+			} else {
+				logger.Warn().Msgf("Policy violation: %s", redactor.String(violation.String()))
+			}
+		}
+
+		if c.Enforce && failed > 0 {
+			return errors.Errorf("%d policy violations with error severity", failed)
+		}
+	}
+
+	output, errE := c.output(docSet)
+	if errE != nil {
+		return errE
 	}
 
-	_, _ = fmt.Fprintf(os.Stdout, "%s", results)
+	if c.Provenance != "" {
+		errE = c.writeProvenance(app, cli, output)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	if c.PhaseDir != "" {
+		return c.writePhases(docSet, phases)
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "%s", output)
 
 	return nil
 }
 
-func ytt(app *App, inspectValues bool, inspectFiles bool, cli *CLI) ([]byte, error) {
-	opts := *yttcmd.NewOptions()
-	opts.InspectFiles = inspectFiles
-	opts.DataValuesFlags.Inspect = inspectValues
-	ui := yttui.NewCustomWriterTTY(false, os.Stdout, os.Stderr)
-	paths := []string{cli.BasePath + "/global"}
+// writeProvenance writes the render provenance manifest of the rendered output.
+func (c *RenderCommand) writeProvenance(app *App, cli *CLI, output []byte) errors.E {
+	manifest, errE := app.RenderManifest(cli, output)
+	if errE != nil {
+		return errE
+	}
+
+	manifest.Format = c.Format
+	manifest.Sort = c.sortStrategy()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.WriteFile(c.Provenance, append(data, '\n'), 0o644) //nolint:gomnd,gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// writePhases writes the documents of each non-empty phase to a file in the
+// phase directory, numbered in apply order, e.g. 01-namespaces.yaml.
+func (c *RenderCommand) writePhases(docSet *yamlmeta.DocumentSet, phases []Phase) errors.E {
+	phases, docSets, errE := PhaseDocuments(docSet, phases)
+	if errE != nil {
+		return errE
+	}
+
+	err := os.MkdirAll(c.PhaseDir, 0o755) //nolint:gomnd
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ext := c.Format
+	if ext == FormatList {
+		ext = FormatJSON
+	}
+
+	for i, phase := range phases {
+		if len(docSets[i].Items) == 0 {
+			continue
+		}
+
+		output, errE := FormatDocuments(docSets[i], c.Format)
+		if errE != nil {
+			return errE
+		}
+
+		path := filepath.Join(c.PhaseDir, fmt.Sprintf("%02d-%s.%s", i+1, phase.Name, ext))
+
+		err = os.WriteFile(path, output, 0o644) //nolint:gomnd,gosec
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, _ = fmt.Fprintf(os.Stdout, "%s\n", path)
+	}
+
+	return nil
+}
+
+// Render renders the manifests of the app as multi-document YAML.
+func (app *App) Render(cli *CLI) ([]byte, error) {
+	docSet, err := app.RenderDocuments(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	return docSet.AsBytes()
+}
+
+// RenderDocuments renders the manifests of the app.
+func (app *App) RenderDocuments(cli *CLI) (*yamlmeta.DocumentSet, error) {
+	logger := cli.GetLoggingConfig().Logger
+
+	err := app.SetTemplatePaths(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info().Msgf("Template paths: %s", app.Paths.Templates)
+
+	charts, errE := app.RenderCharts(cli)
+	if errE != nil {
+		return nil, errE
+	}
+
+	secrets, errE := app.RenderSecrets(cli)
+	if errE != nil {
+		return nil, errE
+	}
+
+	docSet, err := yttDocSet(app, false, false, cli, append(charts, secrets...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	commit := ""
+	if cli.Kinds.Apps.Labels.Enabled && !cli.snapshot {
+		commit = gitCommit()
+	}
+
+	errE = app.InjectLabels(docSet, cli.Kinds.Apps.Labels, commit)
+	if errE != nil {
+		return nil, errE
+	}
+
+	return app.ApplyPostRender(docSet)
+}
+
+// InputPaths returns the paths of the ytt input files and directories of the app in order.
+func (app *App) InputPaths(cli *CLI) []string {
+	paths := append([]string{}, app.Schema...)
+	paths = append(paths, cli.BasePath+"/global")
 	paths = append(paths, app.Env.Paths.RequiredValues...)
+	paths = append(paths, app.Cluster.Paths.RequiredValues...)
 	paths = append(paths, app.Paths.RequiredValues...)
 	paths = append(paths, app.Env.Paths.Required...)
+	paths = append(paths, app.Cluster.Paths.Required...)
 	paths = append(paths, app.Paths.Required...)
+	paths = append(paths, app.Cluster.Paths.Optional...)
 	paths = append(paths, app.Paths.Optional...)
 	paths = append(paths, app.Paths.Templates...)
 
-	files, err := addFiles(opts, paths...)
+	return paths
+}
+
+func ytt(app *App, inspectValues bool, inspectFiles bool, cli *CLI) ([]byte, error) {
+	docSet, err := yttDocSet(app, inspectValues, inspectFiles, cli)
 	if err != nil {
 		return []byte{}, err
 	}
 
+	bs, err := docSet.AsBytes()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return bs, nil
+}
+
+// DataValueOverrides returns the data values set on top of the values files,
+// as key=value.
+func (app *App) DataValueOverrides() []string {
+	return []string{ImageTagValue + "=" + app.Image.Tag}
+}
+
+// yttDocSet runs ytt on the input paths of the app followed by the extra files
+// and returns the resulting documents.
+func yttDocSet(app *App, inspectValues bool, inspectFiles bool, cli *CLI, extra ...*yttfiles.File) (*yamlmeta.DocumentSet, error) {
+	opts := *yttcmd.NewOptions()
+	opts.InspectFiles = inspectFiles
+	opts.DataValuesFlags.Inspect = inspectValues
+	ui := yttui.NewCustomWriterTTY(false, os.Stdout, os.Stderr)
+
+	files, err := yttFiles(app, opts, cli, extra...)
+	if err != nil {
+		return nil, err
+	}
+
 	// Evaluate the template given the configured data values.
 	input := yttcmd.Input{Files: files}
 
-	tag := "app.image.tag=" + app.Image.Tag
-	opts.DataValuesFlags.KVsFromStrings = append(opts.DataValuesFlags.KVsFromStrings, tag)
+	opts.DataValuesFlags.KVsFromStrings = append(opts.DataValuesFlags.KVsFromStrings, app.DataValueOverrides()...)
 
 	output := opts.RunWithFiles(input, ui)
 	if output.Err != nil {
-		return []byte{}, output.Err
+		return nil, output.Err
 	}
 
 	// output.DocSet contains the full set of resulting YAML documents, in order.
-	bs, err := output.DocSet.AsBytes()
+	return output.DocSet, nil
+}
+
+// yttFiles returns the ytt input files of the app, with encrypted files
+// decrypted and vendored libraries added, followed by the extra files.
+func yttFiles(app *App, opts yttcmd.Options, cli *CLI, extra ...*yttfiles.File) ([]*yttfiles.File, error) {
+	files, err := addFiles(opts, app.InputPaths(cli)...)
 	if err != nil {
-		return []byte{}, err
+		return nil, err
 	}
 
-	return bs, nil
+	libraries, errE := cli.VendoredLibraries(opts)
+	if errE != nil {
+		return nil, errE
+	}
+
+	app.InputFiles = append(append(append([]*yttfiles.File{}, files...), libraries...), extra...)
+
+	if app.Decrypted == nil {
+		app.Decrypted = map[string][]byte{}
+	}
+
+	files, app.DecryptedValues, errE = decryptFiles(files, cli.NoSecrets, app.Decrypted)
+	if errE != nil {
+		return nil, errE
+	}
+
+	files = append(files, libraries...)
+
+	return yttfiles.NewSortedFiles(append(files, extra...)), nil
 }
 
+// addFiles returns the ytt input files at yttpaths in order. Kustomization
+// directories are replaced with their built output.
 func addFiles(opts yttcmd.Options, yttpaths ...string) ([]*yttfiles.File, error) {
 	var files []*yttfiles.File
 
-	files, err := yttfiles.NewSortedFilesFromPaths(yttpaths, *opts.RegularFilesSourceOpts.SymlinkAllowOpts)
-	if err != nil {
-		return nil, err
+	for _, path := range yttpaths {
+		if IsKustomization(path) {
+			file, errE := kustomizeBuild(path)
+			if errE != nil {
+				return nil, errE
+			}
+
+			files = append(files, file)
+
+			continue
+		}
+
+		pathFiles, err := yttfiles.NewSortedFilesFromPaths([]string{path}, *opts.RegularFilesSourceOpts.SymlinkAllowOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, pathFiles...)
 	}
 
-	return files, nil
+	return yttfiles.NewSortedFiles(files), nil
 }
 
 func main() {
@@ -407,9 +1005,9 @@ func main() {
 	cli.Run(&c, nil, func(ctx *kong.Context) errors.E {
 		logger := c.GetLoggingConfig().Logger
 
-		path, err := os.Getwd()
+		path, err := c.FindConfig()
 		if err != nil {
-			return errors.Errorf("error getting current working directory: %v", err)
+			return errors.Errorf("error finding config: %v", err)
 		}
 
 		fqpath, err := filepath.Abs(path)
@@ -417,7 +1015,7 @@ func main() {
 			return errors.Errorf("error getting absolute path: %v", err)
 		}
 
-		logger.Warn().Msgf("CLI Config loaded from: %s/%s", fqpath, c.Config)
+		logger.Warn().Msgf("CLI Config loaded from: %s", fqpath)
 		//logger.Debug().Msgf("CLI Config: %s", yaml.NewEncoder(os.Stderr).Encode(&c))
 		return errors.WithStack(ctx.Run(&c))
 	})
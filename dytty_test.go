@@ -2,12 +2,51 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"gitlab.com/tozd/go/errors"
 )
 
+// writeTestFiles writes files with their content relative to dir.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testDataCLI returns a CLI with the paths of the test data configured like
+// in dytty-test-config.yaml.
+func testDataCLI() *CLI {
+	cli := &CLI{
+		BasePath: "test-data",
+		Environments: map[string]Environment{
+			"development": {Paths: EnvPaths{RequiredValues: []string{"test-data/envs/{{.Env.Name}}/values.yaml"}}},
+		},
+	}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{
+		"test-data/apps/{{.Name}}/base-values.yaml",
+		"test-data/apps/{{.Name}}/{{.Env.Name}}/values.yaml",
+		"test-data/apps/{{.Name}}/{{.Env.Name}}/image-tag.yaml",
+	}
+
+	return cli
+}
+
 func TestInvalidEnvName(t *testing.T) {
 	defer func() {
 		if r := recover(); r == nil {
@@ -18,6 +57,15 @@ func TestInvalidEnvName(t *testing.T) {
 	NewEnv("invalid", &cli)
 }
 
+func TestInvalidClusterName(t *testing.T) {
+	cli := CLI{Clusters: map[string]Cluster{"us-east-1": {}}}
+
+	_, err := NewCluster("invalid", &cli)
+	if err == nil {
+		t.Errorf("NewCluster(...): expected an error for an unknown cluster")
+	}
+}
+
 func TestNewEnv(t *testing.T) {
 	name := "development"
 	type args struct {
@@ -41,12 +89,8 @@ func TestNewEnv(t *testing.T) {
 			},
 			want: want{
 				want: &Environment{
-					Name: name,
-					Paths: EnvPaths{
-						Required:       []string{},
-						RequiredValues: []string{"values.yaml"},
-						Optional:       []string{"resources/*.yaml", "overlays.yaml"},
-					},
+					Name:  name,
+					Alias: name,
 				}},
 		},
 	}
@@ -68,24 +112,40 @@ func TestNewApp(t *testing.T) {
 		kind string
 		name string
 		env  string
-		cli  CLI
+		cli  *CLI
 	}
 
 	type want struct {
 		want *App
+		err  bool
 	}
 
+	invalidCluster := testDataCLI()
+	invalidCluster.Clusters = map[string]Cluster{"us-east-1": {}}
+	invalidCluster.Cluster = "invalid"
+
 	cases := map[string]struct {
 		reason string
 		args   args
 		want   want
 	}{
+		"InvalidCluster": {
+			reason: "NewApp should return an error for an unknown cluster",
+			args: args{
+				name: name,
+				kind: kind,
+				env:  env,
+				cli:  invalidCluster,
+			},
+			want: want{err: true},
+		},
 		"NewApp": {
 			reason: "NewApp should return a new App with the correct paths and values",
 			args: args{
 				name: name,
 				kind: kind,
 				env:  env,
+				cli:  testDataCLI(),
 			},
 			want: want{
 				want: &App{
@@ -94,18 +154,15 @@ func TestNewApp(t *testing.T) {
 						Name: name,
 						Kind: kind,
 						Env: Environment{
-							Name: env,
+							Name:  env,
+							Alias: env,
 							Paths: EnvPaths{
 								Required:       []string{},
 								RequiredValues: []string{"test-data/envs/development/values.yaml"},
-								Optional:       []string{"resources/*.yaml", "overlays.yaml"},
 							},
 						},
 						Paths: Paths{
-							Required:       []string{},
-							RequiredValues: []string{},
-							Optional:       []string{"resources/*.yaml", "overlays.yaml"},
-							Templates:      []string{},
+							Templates: []string{},
 						},
 					},
 					Paths: AppsPaths{
@@ -125,14 +182,32 @@ func TestNewApp(t *testing.T) {
 						Repository: "",
 						Registry:   "spanio.jfrog.io",
 					},
+					Metadata: AppMetadata{
+						Environments: []string{},
+					},
+					PathPatterns: []string{
+						"test-data/apps/example/base-values.yaml",
+						"test-data/apps/example/development/values.yaml",
+						"test-data/apps/example/development/image-tag.yaml",
+						"test-data/envs/development/values.yaml",
+					},
 				},
 			},
 		},
 	}
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			tc.args.cli.BasePath = "test-data"
-			got := NewApp(tc.args.kind, tc.args.name, tc.args.env, &tc.args.cli)
+			got, err := NewApp(tc.args.kind, tc.args.name, tc.args.env, tc.args.cli)
+			if tc.want.err {
+				if err == nil {
+					t.Errorf("\n%s\nApp(...): expected an error", tc.reason)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
 			if diff := cmp.Diff(tc.want.want, got); diff != "" {
 				t.Errorf("\n%s\nApp(...): -want, +got:\n%s\n", tc.reason, diff)
 			}
@@ -141,15 +216,20 @@ func TestNewApp(t *testing.T) {
 }
 
 func TestValidatePathsRequiredFilesNotFound(t *testing.T) {
-	prefix := "test-data/apps/example"
-	required := true
-	paths := []string{"reqvalues.yaml"}
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("The code did not panic")
-		}
-	}()
-	ValidatePaths(prefix, required, paths)
+	paths := []string{"test-data/apps/example/reqvalues.yaml"}
+
+	_, err := ValidatePaths(true, paths)
+	if err == nil {
+		t.Errorf("ValidatePaths(...): expected an error for missing required files")
+	}
+
+	got, err := ValidatePaths(false, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{}, got); diff != "" {
+		t.Errorf("ValidatePaths(...): -want, +got:\n%s\n", diff)
+	}
 }
 
 func TestAppSetPaths(t *testing.T) {
@@ -221,6 +301,7 @@ func TestAppSetPaths(t *testing.T) {
 						Optional:       []string{},
 						Templates:      []string{},
 					},
+					PathPatterns: []string{appPrefix + "base-values.yaml", appPrefix + env + "/values.yaml", appPrefix + env + "/image-tag.yaml", envPrefix + "values.yaml"},
 				},
 			},
 		},
@@ -228,7 +309,11 @@ func TestAppSetPaths(t *testing.T) {
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			got := tc.args.app.SetPaths(tc.args.appPrefix, tc.args.envPrefix)
+			got := tc.args.app
+			err := got.SetPaths(testDataCLI())
+			if err != nil {
+				t.Fatal(err)
+			}
 			if diff := cmp.Diff(tc.want.want, got); diff != "" {
 				t.Errorf("\n%s\nAppSetPaths(): -want, +got:\n%s\n", tc.reason, diff)
 			}
@@ -377,7 +462,10 @@ func TestYTT(t *testing.T) {
 	kind := "apps"
 	name := "example"
 	env := "development"
-	appFixture := NewApp(kind, name, env, &CLI{BasePath: "test-data"})
+	appFixture, err := NewApp(kind, name, env, &CLI{BasePath: "test-data"})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	type want struct {
 		want errors.E
@@ -418,3 +506,176 @@ func TestYTT(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvLineage(t *testing.T) {
+	cli := &CLI{
+		Environments: map[string]Environment{
+			"production": {},
+			"prod-us":    {Extends: "prod"},
+			"prod-us-1":  {Extends: "prod-us"},
+			"loop-a":     {Extends: "loop-b"},
+			"loop-b":     {Extends: "loop-a"},
+			"orphan":     {Extends: "missing"},
+		},
+	}
+
+	type want struct {
+		want []string
+		err  bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		env    string
+		want   want
+	}{
+		"NoParent": {
+			reason: "EnvLineage should return only the environment itself",
+			env:    "production",
+			want:   want{want: []string{"production"}},
+		},
+		"Recursive": {
+			reason: "EnvLineage should return the ancestors first with aliases normalized",
+			env:    "prod-us-1",
+			want:   want{want: []string{"production", "prod-us", "prod-us-1"}},
+		},
+		"Cycle": {
+			reason: "EnvLineage should fail on inheritance cycles",
+			env:    "loop-a",
+			want:   want{err: true},
+		},
+		"UnknownParent": {
+			reason: "EnvLineage should fail when extending an unknown environment",
+			env:    "orphan",
+			want:   want{err: true},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := cli.EnvLineage(tc.env)
+			if (err != nil) != tc.want.err {
+				t.Errorf("\n%s\nEnvLineage(...): unexpected error: %v\n", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want.want, got); diff != "" {
+				t.Errorf("\n%s\nEnvLineage(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestCLIValidate(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		cli    CLI
+		err    bool
+	}{
+		"Alias": {
+			reason: "Validate should accept aliases of built-in environments",
+			cli:    CLI{Render: RenderCommand{Env: "prd"}},
+		},
+		"Configured": {
+			reason: "Validate should accept environments defined in the config",
+			cli:    CLI{Values: ValuesCommand{Env: "staging"}, Environments: map[string]Environment{"staging": {}}},
+		},
+		"Invalid": {
+			reason: "Validate should fail on unknown environments",
+			cli:    CLI{Files: FilesCommand{Env: "stagin"}},
+			err:    true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.cli.Validate()
+			if (err != nil) != tc.err {
+				t.Errorf("\n%s\nValidate(): unexpected error: %v\n", tc.reason, err)
+			}
+		})
+	}
+}
+
+func TestNewAppEnvEnabled(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"apps/example/" + AppMetadataFile: "environments: [dev]\n",
+	})
+
+	cases := map[string]struct {
+		reason string
+		env    string
+		err    bool
+	}{
+		"Enabled": {
+			reason: "NewApp should create apps in their enabled environments",
+			env:    "development",
+		},
+		"Disabled": {
+			reason: "NewApp should return an error for disabled environments",
+			env:    "prod",
+			err:    true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewApp("apps", "example", tc.env, &CLI{BasePath: basePath})
+			if (err != nil) != tc.err {
+				t.Errorf("\n%s\nNewApp(...): unexpected error: %v\n", tc.reason, err)
+			}
+		})
+	}
+}
+
+func TestRenderSchema(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"schema.yaml": `#@data/values-schema
+---
+templates: [""]
+app:
+  image:
+    tag: ""
+  replicas: 1
+`,
+		"global/values.yaml":        "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"apps/valid/values.yaml":    "#@data/values\n---\napp:\n  replicas: 3\n",
+		"apps/typo/values.yaml":     "#@data/values\n---\napp:\n  replica: 3\n",
+		"templates/deployment.yaml": "#@ load(\"@ytt:data\", \"data\")\nkind: Deployment\nreplicas: #@ data.values.app.replicas\n",
+	})
+
+	cases := map[string]struct {
+		reason string
+		app    string
+		want   string
+		err    bool
+	}{
+		"Valid": {
+			reason: "Data values should be typed and defaulted by the kind schema",
+			app:    "valid",
+			want:   "kind: Deployment\nreplicas: 3\n",
+		},
+		"Typo": {
+			reason: "Data values not declared in the kind schema should fail",
+			app:    "typo",
+			err:    true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cli := &CLI{BasePath: basePath}
+			cli.Kinds.Apps.Schema = basePath + "/schema.yaml"
+			cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+
+			app, errE := NewApp("apps", tc.app, "development", cli)
+			if errE != nil {
+				t.Fatal(errE)
+			}
+
+			got, err := app.Render(cli)
+			if (err != nil) != tc.err {
+				t.Fatalf("\n%s\nRender(...): unexpected error: %v\n", tc.reason, err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("\n%s\nRender(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/alecthomas/kong v0.8.1
 	github.com/creasty/defaults v1.7.0
 	github.com/google/go-cmp v0.6.0
+	github.com/k14s/starlark-go v0.0.0-20200720175618-3a5c849cc368
 	github.com/rs/zerolog v1.31.1-0.20231108200417-bb14b8b9de11
 	gitlab.com/tozd/go/errors v0.8.1
 	gitlab.com/tozd/go/zerolog v0.6.0
@@ -31,7 +32,6 @@ require (
 	github.com/hashicorp/go-retryablehttp v0.7.1-0.20211018174820-ff6d014e72d9 // indirect
 	github.com/hashicorp/go-version v1.6.0 // indirect
 	github.com/jbenet/go-context v0.0.0-20150711004518-d14ea06fba99 // indirect
-	github.com/k14s/starlark-go v0.0.0-20200720175618-3a5c849cc368 // indirect
 	github.com/kevinburke/ssh_config v1.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	yttfiles "carvel.dev/ytt/pkg/files"
+	"gitlab.com/tozd/go/errors"
+)
+
+// kustomizationFiles are the file names kustomize recognizes as a kustomization.
+//
+//nolint:gochecknoglobals
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// IsKustomization returns true if path is a directory with a kustomization file.
+func IsKustomization(path string) bool {
+	for _, name := range kustomizationFiles {
+		info, err := os.Stat(filepath.Join(path, name))
+		if err == nil && !info.IsDir() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kustomizeBuild builds the kustomization directory at path with kustomize and
+// returns its output as a plain YAML ytt input file.
+func kustomizeBuild(path string) (*yttfiles.File, errors.E) {
+	out, errE := command("kustomize", "build", path)
+	if errE != nil {
+		return nil, errors.WithDetails(errE, "kustomization", path)
+	}
+
+	file, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource(filepath.Join(path, "kustomize-build.yaml"), out))
+	if err != nil {
+		return nil, errors.WithDetails(err, "kustomization", path)
+	}
+
+	// Kustomize output is not a ytt template, but ytt overlays still apply to it.
+	file.MarkTemplate(false)
+
+	return file, nil
+}
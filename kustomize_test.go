@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeKustomize outputs the resources of the kustomization as they are.
+const fakeKustomize = `#!/bin/sh
+cat "$2/resources.yaml"
+`
+
+func TestKustomizeInput(t *testing.T) {
+	writeTestCommand(t, "kustomize", fakeKustomize)
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":                  "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\n",
+		"apps/legacy/values.yaml":             "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"apps/legacy/base/kustomization.yaml": "resources: [resources.yaml]\n",
+		"apps/legacy/base/resources.yaml":     "# Not a ytt comment.\nkind: Service\n",
+		"apps/legacy/overlay/overlay.yaml":    "#@ load(\"@ytt:overlay\", \"overlay\")\n#@overlay/match by=overlay.subset({\"kind\": \"Service\"})\n---\n#@overlay/match missing_ok=True\nspec: {}\n",
+		"templates/deployment.yaml":           "kind: Deployment\n",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+	cli.Kinds.Apps.Paths.Required = []string{basePath + "/apps/{{.Name}}/base", basePath + "/apps/{{.Name}}/overlay"}
+
+	app, errE := NewApp("apps", "legacy", "development", cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	got, err := app.Render(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "kind: Service\nspec: {}\n---\nkind: Deployment\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Render(...): -want, +got:\n%s\n", diff)
+	}
+}
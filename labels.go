@@ -0,0 +1,197 @@
+package main
+
+import (
+	"strings"
+
+	"carvel.dev/ytt/pkg/orderedmap"
+	"carvel.dev/ytt/pkg/yamlmeta"
+	"gitlab.com/tozd/go/errors"
+
+	"github.com/blakebarnett/dytty/cli"
+)
+
+// DefaultLabels are injected when standard labels are enabled without configured labels.
+//
+//nolint:gochecknoglobals
+var DefaultLabels = map[string]string{
+	"app.kubernetes.io/name":       "{{.Name}}",
+	"app.kubernetes.io/instance":   "{{.Name}}-{{.Env.Name}}",
+	"app.kubernetes.io/version":    "{{.Image.Tag}}",
+	"app.kubernetes.io/managed-by": "dytty",
+	"dytty.io/kind":                "{{.Kind}}",
+	"dytty.io/env":                 "{{.Env.Name}}",
+	"dytty.io/team":                "{{.Team}}",
+}
+
+// DefaultAnnotations are injected when standard labels are enabled without
+// configured annotations. The git commit changes every rendered resource with
+// every commit, so it has to be configured explicitly.
+//
+//nolint:gochecknoglobals
+var DefaultAnnotations = map[string]string{
+	"dytty.io/version": "{{.DyttyVersion}}",
+}
+
+// podTemplatePaths are the locations of pod templates in workload resources.
+//
+//nolint:gochecknoglobals
+var podTemplatePaths = [][]string{
+	{"spec", "template"},
+	{"spec", "jobTemplate", "spec", "template"},
+}
+
+// StandardLabels are labels injected into every rendered resource and its pod
+// templates, and annotations injected into every rendered resource. Their values are templates rendered like
+// path templates, with the git commit and the dytty version additionally
+// available as GitCommit and DyttyVersion.
+type StandardLabels struct {
+	Enabled     bool              `yaml:"enabled"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+func dyttyVersion() string {
+	return cli.Version
+}
+
+func dyttyRevision() string {
+	return cli.Revision
+}
+
+// gitCommit returns the commit checked out in the working directory, if any.
+func gitCommit() string {
+	lines, errE := git("rev-parse", "HEAD")
+	if errE != nil || len(lines) == 0 {
+		return ""
+	}
+
+	return lines[0]
+}
+
+// renderLabels renders the templates of the labels, skipping empty values.
+func (app *App) renderLabels(templates map[string]string, data map[string]any) (map[string]string, errors.E) {
+	labels := map[string]string{}
+
+	for key, ts := range templates {
+		value, err := renderPathTemplate(ts, data)
+		if err != nil {
+			return nil, errors.WithDetails(errors.WithStack(err), "label", key)
+		}
+
+		if value = strings.TrimSpace(value); value != "" {
+			labels[key] = value
+		}
+	}
+
+	return labels, nil
+}
+
+// childMap returns the map at key in m, created if missing and create is set,
+// or nil if the value is not a map.
+func childMap(m *orderedmap.Map, key string, create bool) *orderedmap.Map {
+	value, ok := m.Get(key)
+	if !ok && create {
+		child := orderedmap.NewMap()
+		m.Set(key, child)
+
+		return child
+	}
+
+	child, _ := value.(*orderedmap.Map)
+
+	return child
+}
+
+// setMissing sets the values in the map at key in metadata, keeping existing values.
+func setMissing(metadata *orderedmap.Map, key string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+
+	m := childMap(metadata, key, true)
+	if m == nil {
+		return
+	}
+
+	for _, k := range sortedKeys(values) {
+		if _, ok := m.Get(k); !ok {
+			m.Set(k, values[k])
+		}
+	}
+}
+
+// InjectLabels adds the enabled standard labels and annotations to the
+// metadata of every rendered resource, and the labels to its pod templates.
+// Annotations like the git commit change with every commit, so they are not
+// added to pod templates, which would restart pods. Labels and annotations
+// already set by templates are kept. commit is available as GitCommit, and
+// values rendering empty without it are skipped.
+func (app *App) InjectLabels(docSet *yamlmeta.DocumentSet, config StandardLabels, commit string) errors.E {
+	if !config.Enabled {
+		return nil
+	}
+
+	data := app.templateData()
+	data["GitCommit"] = commit
+	data["DyttyVersion"] = dyttyVersion()
+
+	labelTemplates := config.Labels
+	if labelTemplates == nil {
+		labelTemplates = DefaultLabels
+	}
+
+	annotationTemplates := config.Annotations
+	if annotationTemplates == nil {
+		annotationTemplates = DefaultAnnotations
+	}
+
+	labels, errE := app.renderLabels(labelTemplates, data)
+	if errE != nil {
+		return errE
+	}
+
+	annotations, errE := app.renderLabels(annotationTemplates, data)
+	if errE != nil {
+		return errE
+	}
+
+	for _, doc := range docSet.Items {
+		if doc.IsEmpty() {
+			continue
+		}
+
+		value, ok := yamlmeta.NewGoFromAST(doc.Value).(*orderedmap.Map)
+		if !ok {
+			continue
+		}
+
+		metadata := childMap(value, "metadata", len(labels)+len(annotations) > 0)
+		if metadata != nil {
+			setMissing(metadata, "labels", labels)
+			setMissing(metadata, "annotations", annotations)
+		}
+
+		for _, path := range podTemplatePaths {
+			template := value
+			for _, key := range path {
+				if template == nil {
+					break
+				}
+
+				template = childMap(template, key, false)
+			}
+
+			if template == nil {
+				continue
+			}
+
+			if metadata := childMap(template, "metadata", len(labels) > 0); metadata != nil {
+				setMissing(metadata, "labels", labels)
+			}
+		}
+
+		doc.Value = yamlmeta.NewASTFromInterface(value)
+	}
+
+	return nil
+}
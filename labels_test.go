@@ -0,0 +1,206 @@
+package main
+
+import (
+	"testing"
+
+	"carvel.dev/ytt/pkg/yamlmeta"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInjectLabels(t *testing.T) {
+	documents := `kind: Deployment
+metadata:
+  name: web
+  labels:
+    app.kubernetes.io/name: website
+spec:
+  template:
+    spec:
+      containers: []
+---
+kind: CronJob
+metadata:
+  name: cleanup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          labels:
+            job: cleanup
+`
+
+	cases := map[string]struct {
+		reason string
+		config StandardLabels
+		commit string
+		want   string
+	}{
+		"Disabled": {
+			reason: "Nothing should be injected unless enabled",
+			want:   documents,
+		},
+		"Default": {
+			reason: "Default labels should be injected into resources and pod templates, keeping existing labels, without the git commit",
+			config: StandardLabels{Enabled: true},
+			commit: "0123456789abcdef",
+			want: `kind: Deployment
+metadata:
+  name: web
+  labels:
+    app.kubernetes.io/name: website
+    app.kubernetes.io/instance: web-development
+    app.kubernetes.io/managed-by: dytty
+    app.kubernetes.io/version: 1.2.3
+    dytty.io/env: development
+    dytty.io/kind: apps
+    dytty.io/team: platform
+spec:
+  template:
+    spec:
+      containers: []
+    metadata:
+      labels:
+        app.kubernetes.io/instance: web-development
+        app.kubernetes.io/managed-by: dytty
+        app.kubernetes.io/name: web
+        app.kubernetes.io/version: 1.2.3
+        dytty.io/env: development
+        dytty.io/kind: apps
+        dytty.io/team: platform
+---
+kind: CronJob
+metadata:
+  name: cleanup
+  labels:
+    app.kubernetes.io/instance: web-development
+    app.kubernetes.io/managed-by: dytty
+    app.kubernetes.io/name: web
+    app.kubernetes.io/version: 1.2.3
+    dytty.io/env: development
+    dytty.io/kind: apps
+    dytty.io/team: platform
+spec:
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          labels:
+            job: cleanup
+            app.kubernetes.io/instance: web-development
+            app.kubernetes.io/managed-by: dytty
+            app.kubernetes.io/name: web
+            app.kubernetes.io/version: 1.2.3
+            dytty.io/env: development
+            dytty.io/kind: apps
+            dytty.io/team: platform
+`,
+		},
+		"Configured": {
+			reason: "Configured labels and annotations should replace the defaults, skipping empty values",
+			config: StandardLabels{
+				Enabled:     true,
+				Labels:      map[string]string{"example.com/env": "{{.Env.Name}}", "example.com/empty": ""},
+				Annotations: map[string]string{},
+			},
+			want: `kind: Deployment
+metadata:
+  name: web
+  labels:
+    app.kubernetes.io/name: website
+    example.com/env: development
+spec:
+  template:
+    spec:
+      containers: []
+    metadata:
+      labels:
+        example.com/env: development
+---
+kind: CronJob
+metadata:
+  name: cleanup
+  labels:
+    example.com/env: development
+spec:
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          labels:
+            job: cleanup
+            example.com/env: development
+`,
+		},
+		"GitCommit": {
+			reason: "A configured git commit annotation should be injected into resources only",
+			config: StandardLabels{
+				Enabled:     true,
+				Labels:      map[string]string{},
+				Annotations: map[string]string{"dytty.io/git-commit": "{{.GitCommit}}"},
+			},
+			commit: "0123456789abcdef",
+			want: `kind: Deployment
+metadata:
+  name: web
+  labels:
+    app.kubernetes.io/name: website
+  annotations:
+    dytty.io/git-commit: 0123456789abcdef
+spec:
+  template:
+    spec:
+      containers: []
+---
+kind: CronJob
+metadata:
+  name: cleanup
+  annotations:
+    dytty.io/git-commit: 0123456789abcdef
+spec:
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          labels:
+            job: cleanup
+`,
+		},
+		"NoGitCommit": {
+			reason: "A configured git commit annotation should be skipped without a commit, like in snapshots",
+			config: StandardLabels{
+				Enabled:     true,
+				Labels:      map[string]string{},
+				Annotations: map[string]string{"dytty.io/git-commit": "{{.GitCommit}}"},
+			},
+			want: documents,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			docSet, err := yamlmeta.NewDocumentSetFromBytes([]byte(documents), yamlmeta.DocSetOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app := &App{
+				BaseApp:  BaseApp{Name: "web", Kind: "apps", Env: Environment{Name: "development"}},
+				Image:    AppImage{Tag: "1.2.3"},
+				Metadata: AppMetadata{Team: "platform"},
+			}
+
+			errE := app.InjectLabels(docSet, tc.config, tc.commit)
+			if errE != nil {
+				t.Fatal(errE)
+			}
+
+			got, err := docSet.AsBytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("\n%s\nInjectLabels(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// appNameSentinel stands in for the app name when rendering path templates to
+// discover apps. It is not changed by template functions like lower or upper.
+const appNameSentinel = "\x00"
+
+// teamSentinel stands in for the team when rendering path templates to discover
+// apps, as the team is only known from the app metadata. It matches any team.
+const teamSentinel = "\x01"
+
+type ListCommand struct {
+	What string `arg:"" help:"What to list." name:"what" enum:"apps,kinds,envs" yaml:"what"`
+	JSON bool   `help:"Output as JSON."      name:"json"                          yaml:"json"`
+}
+
+type ListedApp struct {
+	Kind         string   `json:"kind"`
+	Name         string   `json:"name"`
+	Team         string   `json:"team,omitempty"`
+	Environments []string `json:"environments"`
+	// Error is set if the metadata of the app cannot be loaded.
+	Error string `json:"error,omitempty"`
+}
+
+// Target is an app in an environment it can be rendered for.
+type Target struct {
+	Kind string `json:"kind"`
+	App  string `json:"app"`
+	Env  string `json:"env"`
+}
+
+type ListedKind struct {
+	Name string `json:"name"`
+	Apps int    `json:"apps"`
+}
+
+type ListedEnv struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Extends string   `json:"extends,omitempty"`
+}
+
+// KindNames returns the kinds which can be configured, only apps so far.
+func (cli *CLI) KindNames() []string {
+	return []string{"apps"}
+}
+
+// EnvNames returns the sorted names of the configured environments, or of the
+// built-in environments when none are configured.
+func (cli *CLI) EnvNames() []string {
+	if len(cli.Environments) == 0 {
+		return []string{"development", "integration", "production"}
+	}
+
+	names := []string{}
+
+	for name := range cli.Environments {
+		if nn, err := NormalizeEnvName(name); err == nil {
+			name = nn
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// envAliases returns the aliases of the built-in environment.
+func envAliases(name string) []string {
+	aliases := []string{}
+
+	for _, alias := range []string{"dev", "int", "prd", "prod"} {
+		if nn, _ := NormalizeEnvName(alias); nn == name {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	return aliases
+}
+
+// DiscoverApps returns the sorted names of the apps of the kind, found by
+// matching the kind's path templates and the metadata file location with the
+// app name and the team as wildcards against the filesystem. Templates which
+// cannot be rendered without an app, e.g. because they use other app
+// metadata, are skipped.
+func (cli *CLI) DiscoverApps(kind string) []string {
+	templates := []string{}
+	templates = append(templates, cli.Kinds.Apps.Paths.Required...)
+	templates = append(templates, cli.Kinds.Apps.Paths.RequiredValues...)
+	templates = append(templates, cli.Kinds.Apps.Paths.Optional...)
+
+	if cli.Kinds.Apps.Metadata != "" {
+		templates = append(templates, cli.Kinds.Apps.Metadata)
+	} else {
+		templates = append(templates, filepath.Join(cli.BasePath, kind, "{{.Name}}", AppMetadataFile))
+	}
+
+	found := map[string]bool{}
+
+	for _, env := range cli.EnvNames() {
+		app := &App{
+			BaseApp: BaseApp{
+				Name: appNameSentinel,
+				Env:  Environment{Name: env, Alias: env},
+				Kind: kind,
+				Vars: cli.Kinds.Apps.Vars,
+			},
+			Metadata: AppMetadata{Team: teamSentinel},
+		}
+
+		for _, ts := range templates {
+			path, err := renderPathTemplate(ts, app.templateData())
+			if err != nil {
+				continue
+			}
+
+			path = filepath.Clean(path)
+			if strings.Count(path, appNameSentinel) != 1 || strings.ContainsAny(path, "*?[") {
+				continue
+			}
+
+			matches, err := filepath.Glob(strings.NewReplacer(appNameSentinel, "*", teamSentinel, "*").Replace(path))
+			if err != nil {
+				continue
+			}
+
+			segment := "[^" + regexp.QuoteMeta(string(filepath.Separator)) + "]+"
+			expr := regexp.MustCompile("^" + strings.NewReplacer(appNameSentinel, "("+segment+")", teamSentinel, segment).Replace(regexp.QuoteMeta(path)) + "$")
+
+			for _, match := range matches {
+				if m := expr.FindStringSubmatch(match); m != nil {
+					found[m[1]] = true
+				}
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range found {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ListApps returns the discovered apps of all kinds with their metadata.
+// Apps which do not restrict their environments are listed with those of them
+// their paths resolve in. Apps whose metadata cannot be loaded are listed
+// with their error and without environments.
+func (cli *CLI) ListApps() ([]ListedApp, errors.E) {
+	logger := cli.GetLoggingConfig().Logger
+	apps := []ListedApp{}
+
+	for _, kind := range cli.KindNames() {
+		for _, name := range cli.DiscoverApps(kind) {
+			app := &App{BaseApp: BaseApp{Name: name, Kind: kind, Vars: cli.Kinds.Apps.Vars}}
+
+			errE := app.loadMetadata(cli)
+			if errE != nil {
+				apps = append(apps, ListedApp{Kind: kind, Name: name, Environments: []string{}, Error: errE.Error()})
+
+				continue
+			}
+
+			environments := []string{}
+
+			for _, env := range cli.EnvNames() {
+				app.Env.Name = env
+				if !app.EnvEnabled() {
+					continue
+				}
+
+				// Declared environments are always listed, so their missing paths are reported.
+				if len(app.Metadata.Environments) == 0 {
+					if _, errE := NewApp(kind, name, env, cli); errE != nil {
+						logger.Debug().Msgf("Skipping env %s for kind: %s, app: %s: %s", env, kind, name, errE)
+
+						continue
+					}
+				}
+
+				environments = append(environments, env)
+			}
+
+			apps = append(apps, ListedApp{
+				Kind:         kind,
+				Name:         name,
+				Team:         app.Metadata.Team,
+				Environments: environments,
+			})
+		}
+	}
+
+	return apps, nil
+}
+
+// loadMetadata loads and applies the metadata file of the app.
+func (app *App) loadMetadata(cli *CLI) errors.E {
+	metadataPath, errE := app.MetadataPath(cli)
+	if errE != nil {
+		return errE
+	}
+
+	metadata, errE := LoadAppMetadata(metadataPath)
+	if errE != nil {
+		return errE
+	}
+
+	return app.applyMetadata(metadata)
+}
+
+// Targets returns all discovered apps in each of their enabled environments.
+// It fails if the metadata of any app cannot be loaded.
+func (cli *CLI) Targets() ([]Target, errors.E) {
+	apps, errE := cli.ListApps()
+	if errE != nil {
+		return nil, errE
+	}
+
+	targets := []Target{}
+
+	for _, app := range apps {
+		if app.Error != "" {
+			return nil, errors.WithDetails(errors.Errorf("cannot list app %s/%s: %s", app.Kind, app.Name, app.Error), "kind", app.Kind, "app", app.Name)
+		}
+
+		for _, env := range app.Environments {
+			targets = append(targets, Target{Kind: app.Kind, App: app.Name, Env: env})
+		}
+	}
+
+	return targets, nil
+}
+
+func (c *ListCommand) Run(cli *CLI) errors.E {
+	logger := cli.GetLoggingConfig().Logger
+	logger.Info().Msgf("Listing %s", c.What)
+
+	var results any
+
+	failed := 0
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0) //nolint:gomnd
+
+	switch c.What {
+	case "apps":
+		apps, errE := cli.ListApps()
+		if errE != nil {
+			return errE
+		}
+
+		results = apps
+
+		_, _ = fmt.Fprintln(w, "KIND\tNAME\tTEAM\tENVIRONMENTS")
+		for _, app := range apps {
+			if app.Error != "" {
+				failed++
+
+				logger.Error().Msgf("Cannot list kind: %s, app: %s: %s", app.Kind, app.Name, app.Error)
+			}
+
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", app.Kind, app.Name, app.Team, strings.Join(app.Environments, ","))
+		}
+	case "kinds":
+		kinds := []ListedKind{}
+		for _, kind := range cli.KindNames() {
+			kinds = append(kinds, ListedKind{Name: kind, Apps: len(cli.DiscoverApps(kind))})
+		}
+
+		results = kinds
+
+		_, _ = fmt.Fprintln(w, "NAME\tAPPS")
+		for _, kind := range kinds {
+			_, _ = fmt.Fprintf(w, "%s\t%d\n", kind.Name, kind.Apps)
+		}
+	case "envs":
+		envs := []ListedEnv{}
+		for _, env := range cli.EnvNames() {
+			envs = append(envs, ListedEnv{Name: env, Aliases: envAliases(env), Extends: cli.Environments[env].Extends})
+		}
+
+		results = envs
+
+		_, _ = fmt.Fprintln(w, "NAME\tALIASES\tEXTENDS")
+		for _, env := range envs {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", env.Name, strings.Join(env.Aliases, ","), env.Extends)
+		}
+	}
+
+	if c.JSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, _ = fmt.Fprintf(os.Stdout, "%s\n", data)
+	} else {
+		err := w.Flush()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d apps cannot be listed", failed)
+	}
+
+	return nil
+}
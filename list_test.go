@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListApps(t *testing.T) {
+	basePath := t.TempDir()
+
+	files := map[string]string{
+		"apps/example/development/values.yaml":  "",
+		"apps/example/" + AppMetadataFile:       "team: platform\nenvironments: [dev]\n",
+		"apps/declared/" + AppMetadataFile:      "environments: [prod]\n",
+		"apps/metadata-only/" + AppMetadataFile: "team: data\n",
+		"apps/env-only/development/values.yaml": "",
+		"apps/multi/development/values.yaml":    "",
+		"apps/multi/production/values.yaml":     "",
+		"apps/not-an-app/README.md":             "",
+	}
+	writeTestFiles(t, basePath, files)
+
+	cli := &CLI{BasePath: basePath}
+	cli.Environments = map[string]Environment{
+		"development": {},
+		"production":  {},
+		"prod-us":     {Extends: "production"},
+	}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{
+		basePath + "/apps/{{.Name}}/{{.Env.Name}}/values.yaml",
+	}
+
+	want := []ListedApp{
+		{Kind: "apps", Name: "declared", Environments: []string{"production"}},
+		{Kind: "apps", Name: "env-only", Environments: []string{"development"}},
+		{Kind: "apps", Name: "example", Team: "platform", Environments: []string{"development"}},
+		{Kind: "apps", Name: "metadata-only", Team: "data", Environments: []string{}},
+		{Kind: "apps", Name: "multi", Environments: []string{"development", "production"}},
+	}
+
+	got, err := cli.ListApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ListApps(): -want, +got:\n%s\n", diff)
+	}
+}
+
+func TestListAppsErrors(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"apps/example/development/values.yaml": "",
+		"apps/broken/" + AppMetadataFile:       "team: [\n",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Environments = map[string]Environment{"development": {}}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/{{.Env.Name}}/values.yaml"}
+
+	got, err := cli.ListApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].Name != "broken" || got[0].Error == "" {
+		t.Errorf("ListApps(): expected the broken app to be listed with its error, got %+v", got)
+	}
+
+	if diff := cmp.Diff(ListedApp{Kind: "apps", Name: "example", Environments: []string{"development"}}, got[1]); diff != "" {
+		t.Errorf("ListApps(): -want, +got:\n%s\n", diff)
+	}
+
+	if _, err := cli.Targets(); err == nil {
+		t.Errorf("Targets(): expected an error for the broken app")
+	}
+}
+
+func TestDiscoverAppsTeam(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"teams/platform/billing/development/values.yaml": "",
+		"teams/data/warehouse/development/values.yaml":   "",
+		"teams/data/README.md":                           "",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Environments = map[string]Environment{"development": {}}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/teams/{{.Team}}/{{.Name}}/{{.Env.Name}}/values.yaml"}
+
+	got := cli.DiscoverApps("apps")
+	if diff := cmp.Diff([]string{"billing", "warehouse"}, got); diff != "" {
+		t.Errorf("DiscoverApps(...) should match any team: -want, +got:\n%s\n", diff)
+	}
+}
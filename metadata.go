@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/tozd/go/errors"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/blakebarnett/dytty/cli"
+)
+
+// AppMetadataFile is the name of the optional per-app metadata file.
+const AppMetadataFile = "dytty-app.yaml"
+
+// AppMetadata is read from an app's metadata file and lets app owners
+// customize the app without editing the central config.
+type AppMetadata struct {
+	Kind         string                       `yaml:"kind"`
+	Team         string                       `yaml:"team"`
+	Environments []string                     `yaml:"environments"`
+	Image        AppImage                     `yaml:"image"`
+	Vars         map[string]string            `yaml:"vars"`
+	Paths        AppsPaths                    `yaml:"paths"`
+	Charts       []Chart                      `yaml:"charts"`
+	Secrets      map[string]map[string]string `yaml:"secrets"`
+}
+
+// MetadataPath returns the location of the app's metadata file, either from
+// the kind's metadata path template or next to the app in the base path.
+func (app *App) MetadataPath(cli *CLI) (string, errors.E) {
+	if cli.Kinds.Apps.Metadata != "" {
+		paths, errE := app.renderPathTemplates([]string{cli.Kinds.Apps.Metadata})
+		if errE != nil {
+			return "", errE
+		}
+
+		return paths[0], nil
+	}
+
+	return filepath.Join(cli.BasePath, app.BaseApp.Kind, app.Name, AppMetadataFile), nil
+}
+
+// LoadAppMetadata reads the metadata file at path. A missing file results in
+// empty metadata. Relative paths in the file are resolved relative to it.
+func LoadAppMetadata(path string) (*AppMetadata, errors.E) {
+	metadata := &AppMetadata{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return metadata, nil
+	} else if err != nil {
+		return nil, errors.WithDetails(err, "path", path)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(metadata)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.WithDetails(err, "path", path)
+	}
+
+	dir := filepath.Dir(path)
+	for _, paths := range []*[]string{&metadata.Paths.Required, &metadata.Paths.RequiredValues, &metadata.Paths.Optional} {
+		for i, p := range *paths {
+			(*paths)[i] = cli.ResolvePath(dir, p)
+		}
+	}
+
+	for i, chart := range metadata.Charts {
+		metadata.Charts[i].Path = cli.ResolvePath(dir, chart.Path)
+	}
+
+	return metadata, nil
+}
+
+// applyMetadata merges the metadata over the kind defaults of the app.
+func (app *App) applyMetadata(metadata *AppMetadata) errors.E {
+	if metadata.Kind != "" && metadata.Kind != app.BaseApp.Kind {
+		return errors.Errorf("app %s is of kind %s, not %s", app.Name, metadata.Kind, app.BaseApp.Kind)
+	}
+
+	environments := []string{}
+
+	for _, e := range metadata.Environments {
+		if nn, err := NormalizeEnvName(e); err == nil {
+			e = nn
+		}
+
+		environments = append(environments, e)
+	}
+
+	metadata.Environments = environments
+
+	if len(metadata.Vars) > 0 {
+		vars := map[string]string{}
+		for k, v := range app.Vars {
+			vars[k] = v
+		}
+
+		for k, v := range metadata.Vars {
+			vars[k] = v
+		}
+
+		app.Vars = vars
+	}
+
+	if metadata.Image.Name != "" {
+		app.Image.Name = metadata.Image.Name
+	}
+
+	if metadata.Image.Tag != "" {
+		app.Image.Tag = metadata.Image.Tag
+	}
+
+	if metadata.Image.Repository != "" {
+		app.Image.Repository = metadata.Image.Repository
+	}
+
+	if metadata.Image.Registry != "" {
+		app.Image.Registry = metadata.Image.Registry
+	}
+
+	app.Metadata = *metadata
+
+	return nil
+}
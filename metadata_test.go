@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blakebarnett/dytty/cli"
+)
+
+func TestLoadAppMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, AppMetadataFile)
+
+	err := os.WriteFile(path, []byte(`kind: apps
+team: platform
+environments: [dev, prd]
+image:
+  name: example
+paths:
+  requiredValues:
+    - extra-values.yaml
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		reason string
+		path   string
+		want   *AppMetadata
+	}{
+		"Missing": {
+			reason: "LoadAppMetadata should return empty metadata when the file does not exist",
+			path:   filepath.Join(dir, "missing.yaml"),
+			want:   &AppMetadata{},
+		},
+		"Exists": {
+			reason: "LoadAppMetadata should resolve paths relative to the metadata file",
+			path:   path,
+			want: &AppMetadata{
+				Kind:         "apps",
+				Team:         "platform",
+				Environments: []string{"dev", "prd"},
+				Image:        AppImage{Name: "example"},
+				Paths: AppsPaths{
+					RequiredValues: []string{cli.ResolvePath(dir, "extra-values.yaml")},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := LoadAppMetadata(tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nLoadAppMetadata(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestApplyMetadata(t *testing.T) {
+	app := &App{
+		BaseApp: BaseApp{Name: "example", Kind: "apps", Vars: map[string]string{"Team": "kind", "Tier": "web"}},
+		Image:   AppImage{Tag: "0.0.0", Registry: "default"},
+	}
+
+	err := app.applyMetadata(&AppMetadata{
+		Team:         "platform",
+		Environments: []string{"dev", "prd"},
+		Image:        AppImage{Registry: "custom"},
+		Vars:         map[string]string{"Tier": "api"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"Team": "platform",
+		"Tier": "api",
+	}
+	got := map[string]any{}
+
+	data := app.templateData()
+	for k := range want {
+		got[k] = data[k]
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("applyMetadata(...) vars: -want, +got:\n%s\n", diff)
+	}
+
+	if diff := cmp.Diff(AppImage{Tag: "0.0.0", Registry: "custom"}, app.Image); diff != "" {
+		t.Errorf("applyMetadata(...) image: -want, +got:\n%s\n", diff)
+	}
+
+	if diff := cmp.Diff([]string{"development", "production"}, app.Metadata.Environments); diff != "" {
+		t.Errorf("applyMetadata(...) environments: -want, +got:\n%s\n", diff)
+	}
+
+	err = app.applyMetadata(&AppMetadata{Kind: "lambda"})
+	if err == nil {
+		t.Errorf("applyMetadata(...) should fail on a kind mismatch")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"carvel.dev/ytt/pkg/orderedmap"
+	"carvel.dev/ytt/pkg/yamlmeta"
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	FormatYAML  = "yaml"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+	FormatList  = "list"
+)
+
+const (
+	SortNone   = "none"
+	SortKapp   = "kapp"
+	SortConfig = "config"
+)
+
+// PhaseAnnotation sets the apply phase of a resource, instead of its kind.
+const PhaseAnnotation = "dytty.io/apply-phase"
+
+// Phase is a group of resources applied together, before those of later phases.
+type Phase struct {
+	Name string `yaml:"name"`
+	// Kinds of the resources in the phase, by default all kinds not in other phases.
+	Kinds []string `yaml:"kinds"`
+}
+
+// KappPhases order resources like kapp, Namespaces and CustomResourceDefinitions
+// first and webhooks last.
+//
+//nolint:gochecknoglobals
+var KappPhases = []Phase{
+	{Name: "namespaces", Kinds: []string{"Namespace"}},
+	{Name: "crds", Kinds: []string{"CustomResourceDefinition"}},
+	{Name: "resources"},
+	{Name: "webhooks", Kinds: []string{"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"}},
+}
+
+// documentPhase returns the index of the phase of the rendered document.
+func documentPhase(doc *yamlmeta.Document, phases []Phase) (int, errors.E) {
+	value := mapValue(doc.AsInterface())
+	kind, _ := value["kind"].(string)
+	metadata, _ := value["metadata"].(map[string]any)
+	annotations, _ := metadata["annotations"].(map[string]any)
+
+	if name, ok := annotations[PhaseAnnotation].(string); ok {
+		for i, phase := range phases {
+			if phase.Name == name {
+				return i, nil
+			}
+		}
+
+		return 0, errors.WithDetails(errors.Errorf("unknown apply phase: %s", name), "kind", kind, "name", metadata["name"])
+	}
+
+	other := -1
+
+	for i, phase := range phases {
+		if len(phase.Kinds) == 0 && other < 0 {
+			other = i
+		}
+
+		for _, k := range phase.Kinds {
+			if k == kind {
+				return i, nil
+			}
+		}
+	}
+
+	return other, nil
+}
+
+// PhaseDocuments splits the documents into the phases, keeping their rendered
+// order within each phase. Documents of kinds not in any phase are added to a
+// last phase named other when no phase includes all other kinds.
+func PhaseDocuments(docSet *yamlmeta.DocumentSet, phases []Phase) ([]Phase, []*yamlmeta.DocumentSet, errors.E) {
+	phases = append([]Phase{}, phases...)
+
+	catchAll := false
+	for _, phase := range phases {
+		catchAll = catchAll || len(phase.Kinds) == 0
+	}
+
+	if !catchAll {
+		phases = append(phases, Phase{Name: "other"})
+	}
+
+	docSets := make([]*yamlmeta.DocumentSet, len(phases))
+	for i := range docSets {
+		docSets[i] = &yamlmeta.DocumentSet{}
+	}
+
+	for _, doc := range docSet.Items {
+		if doc.IsEmpty() {
+			continue
+		}
+
+		i, errE := documentPhase(doc, phases)
+		if errE != nil {
+			return nil, nil, errE
+		}
+
+		docSets[i].Items = append(docSets[i].Items, doc)
+	}
+
+	return phases, docSets, nil
+}
+
+// SortDocuments sorts the documents by their phase.
+func SortDocuments(docSet *yamlmeta.DocumentSet, phases []Phase) errors.E {
+	_, docSets, errE := PhaseDocuments(docSet, phases)
+	if errE != nil {
+		return errE
+	}
+
+	docSet.Items = nil
+	for _, phaseDocSet := range docSets {
+		docSet.Items = append(docSet.Items, phaseDocSet.Items...)
+	}
+
+	return nil
+}
+
+// unorderedValue returns value with ordered maps converted to maps, recursively.
+func unorderedValue(value any) any {
+	switch v := value.(type) {
+	case *orderedmap.Map:
+		result := make(map[string]any, v.Len())
+		v.Iterate(func(k, item any) {
+			result[fmt.Sprint(k)] = unorderedValue(item)
+		})
+
+		return result
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for k, item := range v {
+			result[k] = unorderedValue(item)
+		}
+
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for _, item := range v {
+			result = append(result, unorderedValue(item))
+		}
+
+		return result
+	default:
+		return v
+	}
+}
+
+// marshalJSON returns value as JSON with keys sorted, indented if indent is set.
+func marshalJSON(value any, indent bool) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if indent {
+		encoder.SetIndent("", "  ")
+	}
+
+	err := encoder.Encode(unorderedValue(value))
+
+	return buf.Bytes(), err
+}
+
+// FormatDocuments returns the documents as multi-document YAML, a stream of
+// JSON documents, JSON lines or a v1 List in JSON.
+func FormatDocuments(docSet *yamlmeta.DocumentSet, format string) ([]byte, errors.E) {
+	if format == "" || format == FormatYAML {
+		data, err := docSet.AsBytes()
+
+		return data, errors.WithStack(err)
+	}
+
+	items := []any{}
+
+	for _, doc := range docSet.Items {
+		if !doc.IsEmpty() {
+			items = append(items, doc.AsInterface())
+		}
+	}
+
+	switch format {
+	case FormatJSON, FormatJSONL:
+		var buf bytes.Buffer
+
+		for _, item := range items {
+			data, err := marshalJSON(item, format == FormatJSON)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			buf.Write(data)
+		}
+
+		return buf.Bytes(), nil
+	case FormatList:
+		data, err := marshalJSON(map[string]any{"apiVersion": "v1", "kind": "List", "items": items}, true)
+
+		return data, errors.WithStack(err)
+	default:
+		return nil, errors.Errorf("unknown output format: %s", format)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"testing"
+
+	"carvel.dev/ytt/pkg/yamlmeta"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testDocuments = `kind: Deployment
+metadata:
+  name: web
+---
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+---
+kind: Service
+metadata:
+  name: web
+---
+kind: Namespace
+metadata:
+  name: web
+`
+
+func TestFormatDocuments(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		format string
+		phases []Phase
+		want   string
+	}{
+		"YAML": {
+			reason: "Documents should be formatted as multi-document YAML",
+			format: FormatYAML,
+			want:   testDocuments,
+		},
+		"Sorted": {
+			reason: "Sorted documents should start with Namespaces and CustomResourceDefinitions",
+			format: FormatJSONL,
+			phases: KappPhases,
+			want: `{"kind":"Namespace","metadata":{"name":"web"}}
+{"kind":"CustomResourceDefinition","metadata":{"name":"widgets.example.com"}}
+{"kind":"Deployment","metadata":{"name":"web"}}
+{"kind":"Service","metadata":{"name":"web"}}
+`,
+		},
+		"JSON": {
+			reason: "Documents should be formatted as a stream of indented JSON documents",
+			format: FormatJSON,
+			phases: KappPhases,
+			want: `{
+  "kind": "Namespace",
+  "metadata": {
+    "name": "web"
+  }
+}
+{
+  "kind": "CustomResourceDefinition",
+  "metadata": {
+    "name": "widgets.example.com"
+  }
+}
+{
+  "kind": "Deployment",
+  "metadata": {
+    "name": "web"
+  }
+}
+{
+  "kind": "Service",
+  "metadata": {
+    "name": "web"
+  }
+}
+`,
+		},
+		"List": {
+			reason: "Documents should be wrapped into a v1 List",
+			format: FormatList,
+			want: `{
+  "apiVersion": "v1",
+  "items": [
+    {
+      "kind": "Deployment",
+      "metadata": {
+        "name": "web"
+      }
+    },
+    {
+      "kind": "CustomResourceDefinition",
+      "metadata": {
+        "name": "widgets.example.com"
+      }
+    },
+    {
+      "kind": "Service",
+      "metadata": {
+        "name": "web"
+      }
+    },
+    {
+      "kind": "Namespace",
+      "metadata": {
+        "name": "web"
+      }
+    }
+  ],
+  "kind": "List"
+}
+`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			docSet, err := yamlmeta.NewDocumentSetFromBytes([]byte(testDocuments), yamlmeta.DocSetOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.phases != nil {
+				errE := SortDocuments(docSet, tc.phases)
+				if errE != nil {
+					t.Fatal(errE)
+				}
+			}
+
+			got, errE := FormatDocuments(docSet, tc.format)
+			if errE != nil {
+				t.Fatal(errE)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("\n%s\nFormatDocuments(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestPhaseDocuments(t *testing.T) {
+	webhook := `---
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: widgets
+`
+	job := `---
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    ` + PhaseAnnotation + `: setup
+`
+
+	type want struct {
+		phases []string
+		kinds  [][]string
+		err    bool
+	}
+
+	cases := map[string]struct {
+		reason    string
+		documents string
+		phases    []Phase
+		want      want
+	}{
+		"Kapp": {
+			reason:    "Documents should be split into the kapp phases",
+			documents: testDocuments + webhook,
+			phases:    KappPhases,
+			want: want{
+				phases: []string{"namespaces", "crds", "resources", "webhooks"},
+				kinds: [][]string{
+					{"Namespace"},
+					{"CustomResourceDefinition"},
+					{"Deployment", "Service"},
+					{"ValidatingWebhookConfiguration"},
+				},
+			},
+		},
+		"Config": {
+			reason:    "Documents should be split into the configured phases by kind or annotation",
+			documents: testDocuments + webhook + job,
+			phases: []Phase{
+				{Name: "setup", Kinds: []string{"Namespace", "CustomResourceDefinition"}},
+				{Name: "workloads", Kinds: []string{"Deployment"}},
+			},
+			want: want{
+				phases: []string{"setup", "workloads", "other"},
+				kinds: [][]string{
+					{"CustomResourceDefinition", "Namespace", "Job"},
+					{"Deployment"},
+					{"Service", "ValidatingWebhookConfiguration"},
+				},
+			},
+		},
+		"UnknownPhase": {
+			reason:    "Documents annotated with an unknown phase should fail",
+			documents: testDocuments + job,
+			phases:    KappPhases,
+			want:      want{err: true},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			docSet, err := yamlmeta.NewDocumentSetFromBytes([]byte(tc.documents), yamlmeta.DocSetOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			phases, docSets, errE := PhaseDocuments(docSet, tc.phases)
+
+			got := want{err: errE != nil}
+			for i, phase := range phases {
+				got.phases = append(got.phases, phase.Name)
+				kinds := []string{}
+				for _, doc := range docSets[i].Items {
+					kinds = append(kinds, mapValue(doc.AsInterface())["kind"].(string))
+				}
+				got.kinds = append(got.kinds, kinds)
+			}
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(want{})); diff != "" {
+				t.Errorf("\n%s\nPhaseDocuments(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRenderCommandPhases(t *testing.T) {
+	configured := []Phase{{Name: "all"}}
+
+	cases := map[string]struct {
+		reason  string
+		command RenderCommand
+		want    []Phase
+	}{
+		"None": {
+			reason:  "Documents should not be sorted by default",
+			command: RenderCommand{Sort: SortNone, Phases: configured},
+			want:    nil,
+		},
+		"Kapp": {
+			reason:  "The kapp strategy should use the kapp phases",
+			command: RenderCommand{Sort: SortKapp},
+			want:    KappPhases,
+		},
+		"Config": {
+			reason:  "The config strategy should use the configured phases",
+			command: RenderCommand{Sort: SortConfig, Phases: configured},
+			want:    configured,
+		},
+		"SortKinds": {
+			reason:  "The deprecated --sort-kinds should be an alias of --sort kapp",
+			command: RenderCommand{Sort: SortNone, SortKinds: true},
+			want:    KappPhases,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.command.phases()
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nphases(): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/k14s/starlark-go/starlark"
+	"gitlab.com/tozd/go/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// violationsKey is the thread local under which deny and warn record violations.
+const violationsKey = "violations"
+
+// Policy is a Starlark file defining a check(doc, target) function, which is
+// called for every rendered document and reports violations by calling
+// deny(message) or warn(message).
+type Policy struct {
+	Name  string
+	check starlark.Value
+}
+
+type Violation struct {
+	Policy   string `json:"policy"`
+	Severity string `json:"severity"`
+	Resource string `json:"resource"`
+	Message  string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", v.Severity, v.Resource, v.Message, v.Policy)
+}
+
+// violationBuiltin returns a builtin recording a violation with severity.
+func violationBuiltin(name, severity string) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var message string
+
+		err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &message)
+		if err != nil {
+			return nil, err
+		}
+
+		violations, ok := thread.Local(violationsKey).(*[]Violation)
+		if !ok {
+			return nil, fmt.Errorf("%s: called outside of check", fn.Name())
+		}
+
+		*violations = append(*violations, Violation{Severity: severity, Message: message})
+
+		return starlark.None, nil
+	})
+}
+
+// policyBuiltins are the functions available in policies to report violations.
+//
+//nolint:gochecknoglobals
+var policyBuiltins = starlark.StringDict{
+	"deny": violationBuiltin("deny", SeverityError),
+	"warn": violationBuiltin("warn", SeverityWarning),
+}
+
+// LoadPolicies loads all *.star files in dir, sorted by name.
+func LoadPolicies(dir string) ([]*Policy, errors.E) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.Strings(paths)
+
+	policies := []*Policy{}
+
+	for _, path := range paths {
+		thread := &starlark.Thread{Name: path}
+
+		globals, err := starlark.ExecFile(thread, path, nil, policyBuiltins)
+		if err != nil {
+			return nil, errors.WithDetails(err, "policy", path)
+		}
+
+		check, ok := globals["check"].(starlark.Callable)
+		if !ok {
+			return nil, errors.WithDetails(errors.New("policy does not define a check function"), "policy", path)
+		}
+
+		policies = append(policies, &Policy{Name: strings.TrimSuffix(filepath.Base(path), ".star"), check: check})
+	}
+
+	return policies, nil
+}
+
+// toStarlark converts a value decoded from YAML into a Starlark value.
+func toStarlark(value any) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case string:
+		return starlark.String(v), nil
+	case time.Time:
+		return starlark.String(v.Format(time.RFC3339)), nil
+	case []any:
+		list := make([]starlark.Value, 0, len(v))
+
+		for _, item := range v {
+			s, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, s)
+		}
+
+		return starlark.NewList(list), nil
+	case map[string]any:
+		dict := starlark.NewDict(len(v))
+
+		for key, item := range v {
+			s, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+
+			err = dict.SetKey(starlark.String(key), s)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// resourceName returns kind/namespace/name of a rendered document.
+func resourceName(doc map[string]any) string {
+	metadata, _ := doc["metadata"].(map[string]any)
+	parts := []string{}
+
+	for _, part := range []any{doc["kind"], metadata["namespace"], metadata["name"]} {
+		if s, ok := part.(string); ok && s != "" {
+			parts = append(parts, s)
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// CheckPolicies evaluates the policies in the configured policies directory
+// against every document of the rendered manifests of the app.
+func (cli *CLI) CheckPolicies(app *App, rendered []byte) ([]Violation, errors.E) {
+	policies, errE := LoadPolicies(cli.Policies)
+	if errE != nil {
+		return nil, errE
+	}
+
+	target := starlark.NewDict(5) //nolint:gomnd
+	for key, value := range map[string]string{
+		"kind":    app.Kind,
+		"app":     app.Name,
+		"env":     app.Env.Name,
+		"cluster": app.Cluster.Name,
+		"team":    app.Metadata.Team,
+	} {
+		_ = target.SetKey(starlark.String(key), starlark.String(value))
+	}
+
+	target.Freeze()
+
+	violations := []Violation{}
+	decoder := yaml.NewDecoder(strings.NewReader(string(rendered)))
+
+	for i := 0; ; i++ {
+		var doc map[string]any
+
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		} else if doc == nil {
+			continue
+		}
+
+		value, err := toStarlark(doc)
+		if err != nil {
+			return nil, errors.WithDetails(err, "document", i)
+		}
+
+		value.Freeze()
+
+		resource := resourceName(doc)
+		if resource == "" {
+			resource = fmt.Sprintf("document %d", i)
+		}
+
+		for _, policy := range policies {
+			found := []Violation{}
+			thread := &starlark.Thread{Name: policy.Name}
+			thread.SetLocal(violationsKey, &found)
+
+			_, err := starlark.Call(thread, policy.check, starlark.Tuple{value, target}, nil)
+			if err != nil {
+				return nil, errors.WithDetails(err, "policy", policy.Name, "resource", resource)
+			}
+
+			for _, violation := range found {
+				violation.Policy = policy.Name
+				violation.Resource = resource
+				violations = append(violations, violation)
+			}
+		}
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckPolicies(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"latest.star": `
+def check(doc, target):
+    for container in doc.get("spec", {}).get("containers", []):
+        if container["image"].endswith(":latest"):
+            deny("container %s uses the latest tag" % container["name"])
+`,
+		"limits.star": `
+def check(doc, target):
+    if target["env"] != "production" or doc["kind"] != "Pod":
+        return
+    for container in doc["spec"]["containers"]:
+        if "limits" not in container.get("resources", {}):
+            deny("container %s has no resource limits" % container["name"])
+`,
+		"labels.star": `
+def check(doc, target):
+    if "team" not in doc["metadata"].get("labels", {}):
+        warn("missing team label")
+`,
+		"README.md": "not a policy",
+	})
+
+	rendered := `kind: Pod
+metadata:
+  name: web
+  namespace: default
+  labels:
+    team: platform
+spec:
+  containers:
+  - name: web
+    image: web:latest
+---
+kind: ConfigMap
+metadata:
+  name: config
+`
+
+	cli := &CLI{Policies: dir}
+
+	cases := map[string]struct {
+		reason string
+		env    string
+		want   []Violation
+	}{
+		"Development": {
+			reason: "Policies should only report violations which apply to the env",
+			env:    "development",
+			want: []Violation{
+				{Policy: "latest", Severity: SeverityError, Resource: "Pod/default/web", Message: "container web uses the latest tag"},
+				{Policy: "labels", Severity: SeverityWarning, Resource: "ConfigMap/config", Message: "missing team label"},
+			},
+		},
+		"Production": {
+			reason: "Policies should be evaluated against every document with the target",
+			env:    "production",
+			want: []Violation{
+				{Policy: "latest", Severity: SeverityError, Resource: "Pod/default/web", Message: "container web uses the latest tag"},
+				{Policy: "limits", Severity: SeverityError, Resource: "Pod/default/web", Message: "container web has no resource limits"},
+				{Policy: "labels", Severity: SeverityWarning, Resource: "ConfigMap/config", Message: "missing team label"},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			app := &App{}
+			app.Name = "web"
+			app.Kind = "apps"
+			app.Env.Name = tc.env
+
+			got, err := cli.CheckPolicies(app, []byte(rendered))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nCheckPolicies(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	yttcmd "carvel.dev/ytt/pkg/cmd/template"
+	yttui "carvel.dev/ytt/pkg/cmd/ui"
+	yttfiles "carvel.dev/ytt/pkg/files"
+	"carvel.dev/ytt/pkg/orderedmap"
+	"carvel.dev/ytt/pkg/yamlmeta"
+	"gitlab.com/tozd/go/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// PostRender is a step applied to the rendered documents after ytt, either a
+// ytt overlay file or directory, or a file with JSON patches.
+type PostRender struct {
+	Overlay string `yaml:"overlay"`
+	Patch   string `yaml:"patch"`
+}
+
+// Patch is a list of JSON patch (RFC 6902) operations applied to the rendered
+// documents matching its target, or to all documents if it has no target.
+type Patch struct {
+	Target     PatchTarget      `yaml:"target"`
+	Operations []PatchOperation `yaml:"patch"`
+}
+
+type PatchTarget struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+	Namespace  string `yaml:"namespace"`
+}
+
+type PatchOperation struct {
+	Op    string `yaml:"op"`
+	Path  string `yaml:"path"`
+	From  string `yaml:"from"`
+	Value any    `yaml:"value"`
+}
+
+func (app *App) renderPostRender(steps []PostRender) ([]PostRender, errors.E) {
+	var results []PostRender
+
+	for _, step := range steps {
+		paths, errE := app.renderPathTemplates([]string{step.Overlay, step.Patch})
+		if errE != nil {
+			return nil, errE
+		}
+
+		results = append(results, PostRender{Overlay: paths[0], Patch: paths[1]})
+	}
+
+	return results, nil
+}
+
+// PostRenderPaths returns the paths of the overlays and patches of the app in order.
+func (app *App) PostRenderPaths() []string {
+	paths := []string{}
+
+	for _, step := range app.PostRender {
+		if step.Overlay != "" {
+			paths = append(paths, step.Overlay)
+		}
+
+		if step.Patch != "" {
+			paths = append(paths, step.Patch)
+		}
+	}
+
+	return paths
+}
+
+// ApplyPostRender applies the post-render steps of the app to the rendered documents in order.
+func (app *App) ApplyPostRender(docSet *yamlmeta.DocumentSet) (*yamlmeta.DocumentSet, error) {
+	for _, step := range app.PostRender {
+		var err error
+
+		if step.Overlay != "" {
+			docSet, err = applyOverlay(docSet, step.Overlay)
+			if err != nil {
+				return nil, errors.WithDetails(err, "overlay", step.Overlay)
+			}
+		}
+
+		if step.Patch != "" {
+			err = applyPatchFile(docSet, step.Patch)
+			if err != nil {
+				return nil, errors.WithDetails(err, "patch", step.Patch)
+			}
+		}
+	}
+
+	return docSet, nil
+}
+
+// applyOverlay runs ytt with the rendered documents followed by the overlay files at path.
+func applyOverlay(docSet *yamlmeta.DocumentSet, path string) (*yamlmeta.DocumentSet, error) {
+	opts := *yttcmd.NewOptions()
+	ui := yttui.NewCustomWriterTTY(false, os.Stdout, os.Stderr)
+
+	rendered, err := docSet.AsBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource("rendered.yaml", rendered))
+	if err != nil {
+		return nil, err
+	}
+
+	overlays, err := addFiles(opts, path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := yttfiles.NewSortedFiles(append([]*yttfiles.File{file}, overlays...))
+
+	output := opts.RunWithFiles(yttcmd.Input{Files: files}, ui)
+	if output.Err != nil {
+		return nil, output.Err
+	}
+
+	return output.DocSet, nil
+}
+
+func applyPatchFile(docSet *yamlmeta.DocumentSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var patches []Patch
+
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(&patches)
+	if err != nil {
+		return err
+	}
+
+	for _, patch := range patches {
+		for _, doc := range docSet.Items {
+			if doc.IsEmpty() || !patch.Target.matches(doc) {
+				continue
+			}
+
+			value, err := applyOperations(yamlmeta.NewGoFromAST(doc.Value), patch.Operations)
+			if err != nil {
+				return errors.WithDetails(err, "resource", resourceName(mapValue(doc.AsInterface())))
+			}
+
+			doc.Value = yamlmeta.NewASTFromInterface(value)
+		}
+	}
+
+	return nil
+}
+
+// mapValue converts a value of a rendered document into unordered maps.
+func mapValue(value any) map[string]any {
+	m, _ := orderedmap.Conversion{Object: value}.AsUnorderedStringMaps().(map[string]any)
+
+	return m
+}
+
+func (t PatchTarget) matches(doc *yamlmeta.Document) bool {
+	value := mapValue(doc.AsInterface())
+	metadata, _ := value["metadata"].(map[string]any)
+
+	return matchesField(t.APIVersion, value["apiVersion"]) && matchesField(t.Kind, value["kind"]) &&
+		matchesField(t.Name, metadata["name"]) && matchesField(t.Namespace, metadata["namespace"])
+}
+
+// matchesField returns true if want is empty or equal to got.
+func matchesField(want string, got any) bool {
+	return want == "" || want == got
+}
+
+// applyOperations applies JSON patch operations to value and returns the result.
+func applyOperations(value any, operations []PatchOperation) (any, error) {
+	for _, operation := range operations {
+		path, err := jsonPointer(operation.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		from, err := jsonPointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+
+		patch := orderedmap.Conversion{Object: operation.Value}.FromUnorderedMaps()
+
+		switch operation.Op {
+		case "add":
+			value, err = addValue(value, path, patch, false)
+		case "replace":
+			value, err = addValue(value, path, patch, true)
+		case "remove":
+			value, _, err = removeValue(value, path)
+		case "move":
+			var moved any
+
+			value, moved, err = removeValue(value, from)
+			if err == nil {
+				value, err = addValue(value, path, moved, false)
+			}
+		case "copy":
+			var copied any
+
+			copied, err = getValue(value, from)
+			if err == nil {
+				value, err = addValue(value, path, copyValue(copied), false)
+			}
+		case "test":
+			var got any
+
+			got, err = getValue(value, path)
+			if err == nil && !reflect.DeepEqual(mapValue(map[string]any{"v": got}), mapValue(map[string]any{"v": patch})) {
+				err = errors.New("test failed")
+			}
+		default:
+			err = errors.New("unknown operation")
+		}
+
+		if err != nil {
+			return nil, errors.WithDetails(err, "op", operation.Op, "path", operation.Path)
+		}
+	}
+
+	return value, nil
+}
+
+// copyValue returns a deep copy of value, so a copied value and its source can
+// be patched independently.
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case *orderedmap.Map:
+		result := orderedmap.NewMap()
+		v.Iterate(func(k, item any) {
+			result.Set(k, copyValue(item))
+		})
+
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for _, item := range v {
+			result = append(result, copyValue(item))
+		}
+
+		return result
+	default:
+		return v
+	}
+}
+
+// jsonPointer splits a JSON pointer (RFC 6901) into its reference tokens.
+func jsonPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer: %s", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses token as an index into array, allowing the index past the
+// last element (also written as -) when adding.
+func arrayIndex(array []any, token string, adding bool) (int, error) {
+	if token == "-" && adding {
+		return len(array), nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > len(array) || (i == len(array) && !adding) {
+		return 0, fmt.Errorf("invalid array index: %s", token)
+	}
+
+	return i, nil
+}
+
+func getValue(value any, path []string) (any, error) {
+	for _, token := range path {
+		switch v := value.(type) {
+		case *orderedmap.Map:
+			child, ok := v.Get(token)
+			if !ok {
+				return nil, fmt.Errorf("missing key: %s", token)
+			}
+
+			value = child
+		case []any:
+			i, err := arrayIndex(v, token, false)
+			if err != nil {
+				return nil, err
+			}
+
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("cannot index %T with %s", value, token)
+		}
+	}
+
+	return value, nil
+}
+
+// addValue sets the value at path to patch, inserting it into arrays, and
+// returns the updated value. With replace the value at path has to exist.
+func addValue(value any, path []string, patch any, replace bool) (any, error) {
+	if len(path) == 0 {
+		return patch, nil
+	}
+
+	token, last := path[0], len(path) == 1
+
+	switch v := value.(type) {
+	case *orderedmap.Map:
+		child, ok := v.Get(token)
+
+		switch {
+		case last && replace && !ok:
+			return nil, fmt.Errorf("missing key: %s", token)
+		case last:
+			v.Set(token, patch)
+		case !ok:
+			return nil, fmt.Errorf("missing key: %s", token)
+		default:
+			child, err := addValue(child, path[1:], patch, replace)
+			if err != nil {
+				return nil, err
+			}
+
+			v.Set(token, child)
+		}
+
+		return v, nil
+	case []any:
+		i, err := arrayIndex(v, token, last && !replace)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case last && replace:
+			v[i] = patch
+		case last:
+			v = append(v[:i], append([]any{patch}, v[i:]...)...)
+		default:
+			v[i], err = addValue(v[i], path[1:], patch, replace)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return v, nil
+	default:
+		return nil, fmt.Errorf("cannot index %T with %s", value, token)
+	}
+}
+
+// removeValue removes the value at path and returns the updated and the removed value.
+func removeValue(value any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the document")
+	}
+
+	token, last := path[0], len(path) == 1
+
+	switch v := value.(type) {
+	case *orderedmap.Map:
+		child, ok := v.Get(token)
+		if !ok {
+			return nil, nil, fmt.Errorf("missing key: %s", token)
+		}
+
+		if last {
+			v.Delete(token)
+
+			return v, child, nil
+		}
+
+		child, removed, err := removeValue(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		v.Set(token, child)
+
+		return v, removed, nil
+	case []any:
+		i, err := arrayIndex(v, token, false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if last {
+			removed := v[i]
+
+			return append(v[:i], v[i+1:]...), removed, nil
+		}
+
+		var removed any
+
+		v[i], removed, err = removeValue(v[i], path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return v, removed, nil
+	default:
+		return nil, nil, fmt.Errorf("cannot index %T with %s", value, token)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"carvel.dev/ytt/pkg/yamlmeta"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyPostRender(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"overlays/sidecar.yaml": `#@ load("@ytt:overlay", "overlay")
+#@overlay/match by=overlay.subset({"kind": "Deployment"})
+---
+spec:
+  template:
+    spec:
+      containers:
+      #@overlay/append
+      - name: proxy
+        image: proxy:1.0.0
+`,
+		"patches/replicas.yaml": `- target:
+    kind: Deployment
+    name: web
+  patch:
+  - op: add
+    path: /spec/replicas
+    value: 3
+  - op: replace
+    path: /spec/template/spec/containers/0/image
+    value: web:1.2.3
+`,
+		"patches/labels.yaml": `- patch:
+  - op: add
+    path: /metadata/labels
+    value: {team: platform}
+`,
+		"patches/invalid.yaml": `- patch:
+  - op: replace
+    path: /metadata/missing
+    value: true
+`,
+	})
+
+	rendered := `kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:latest
+---
+kind: Service
+metadata:
+  name: web
+`
+
+	cases := map[string]struct {
+		reason string
+		steps  []PostRender
+		want   string
+		err    bool
+	}{
+		"None": {
+			reason: "Without post-render steps the documents should not change",
+			want:   rendered,
+		},
+		"Overlay": {
+			reason: "Overlays should be applied to the rendered documents",
+			steps:  []PostRender{{Overlay: filepath.Join(dir, "overlays")}},
+			want: `kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:latest
+      - name: proxy
+        image: proxy:1.0.0
+---
+kind: Service
+metadata:
+  name: web
+`,
+		},
+		"Patches": {
+			reason: "Patches should be applied in order to the documents matching their target",
+			steps: []PostRender{
+				{Patch: filepath.Join(dir, "patches/replicas.yaml")},
+				{Patch: filepath.Join(dir, "patches/labels.yaml")},
+			},
+			want: `kind: Deployment
+metadata:
+  name: web
+  labels:
+    team: platform
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.2.3
+  replicas: 3
+---
+kind: Service
+metadata:
+  name: web
+  labels:
+    team: platform
+`,
+		},
+		"Invalid": {
+			reason: "Replacing a missing value should fail",
+			steps:  []PostRender{{Patch: filepath.Join(dir, "patches/invalid.yaml")}},
+			err:    true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			docSet, err := yamlmeta.NewDocumentSetFromBytes([]byte(rendered), yamlmeta.DocSetOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app := &App{PostRender: tc.steps}
+
+			docSet, err = app.ApplyPostRender(docSet)
+			if tc.err {
+				if err == nil {
+					t.Errorf("\n%s\nApplyPostRender(...): expected error", tc.reason)
+				}
+
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := docSet.AsBytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("\n%s\nApplyPostRender(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestApplyOperationsCopy(t *testing.T) {
+	docSet, err := yamlmeta.NewDocumentSetFromBytes([]byte("metadata:\n  labels:\n    app: web\n"), yamlmeta.DocSetOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := []PatchOperation{
+		{Op: "copy", From: "/metadata/labels", Path: "/metadata/annotations"},
+		{Op: "add", Path: "/metadata/annotations/team", Value: "platform"},
+	}
+
+	value, err := applyOperations(yamlmeta.NewGoFromAST(docSet.Items[0].Value), operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"metadata": map[string]any{
+			"labels":      map[string]any{"app": "web"},
+			"annotations": map[string]any{"app": "web", "team": "platform"},
+		},
+	}
+	if diff := cmp.Diff(want, mapValue(value)); diff != "" {
+		t.Errorf("applyOperations(...): patching a copy should not change its source: -want, +got:\n%s\n", diff)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yttcmd "carvel.dev/ytt/pkg/cmd/template"
+	yttfiles "carvel.dev/ytt/pkg/files"
+	"gitlab.com/tozd/go/errors"
+)
+
+// RenderManifestFile is the conventional name of render provenance manifests.
+const RenderManifestFile = "render-manifest.json"
+
+// InputFile is an input file of a render with the SHA-256 digest of its
+// contents. Generated files, like rendered charts, exist only in memory.
+type InputFile struct {
+	Path      string `json:"path"`
+	Digest    string `json:"digest"`
+	Generated bool   `json:"generated,omitempty"`
+}
+
+// RenderManifest records how the manifests of an app were rendered, so they
+// can be traced to their inputs and reproduced.
+type RenderManifest struct {
+	Version      string `json:"version"`
+	Revision     string `json:"revision"`
+	Config       string `json:"config,omitempty"`
+	ConfigDigest string `json:"configDigest,omitempty"`
+	// ConfigFiles are all loaded config files, included ones first, and ConfigEnv
+	// the DYTTY_ environment variables applied on top of them.
+	ConfigFiles []InputFile `json:"configFiles,omitempty"`
+	ConfigEnv   []string    `json:"configEnv,omitempty"`
+	GitCommit   string      `json:"gitCommit,omitempty"`
+	Kind        string      `json:"kind"`
+	App         string      `json:"app"`
+	Env         string      `json:"env"`
+	Cluster     string      `json:"cluster,omitempty"`
+	ImageTag    string      `json:"imageTag"`
+	NoSecrets   bool        `json:"noSecrets,omitempty"`
+	DataValues  []string    `json:"dataValues"`
+	Files       []InputFile `json:"files"`
+	// Format and Sort are the output format and sort strategy of the render.
+	Format string `json:"format,omitempty"`
+	Sort   string `json:"sort,omitempty"`
+	// OutputDigest is the digest of the output in the format and sort recorded,
+	// as printed without a phase directory.
+	OutputDigest string `json:"outputDigest"`
+}
+
+// digest returns the SHA-256 digest of data.
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ConfigFileLoaded records a loaded config file with the digest of its contents.
+func (c *CLI) ConfigFileLoaded(path string, data []byte) {
+	c.configFiles = append(c.configFiles, InputFile{Path: path, Digest: digest(data)})
+}
+
+// ConfigEnvApplied records an environment variable overriding the config.
+func (c *CLI) ConfigEnvApplied(name, value string) {
+	c.configEnv = append(c.configEnv, name+"="+value)
+}
+
+// configRelPath returns path relative to the directory of the config file in
+// use, so manifests do not depend on the working directory.
+func (c *CLI) configRelPath(path string) string {
+	dir, errE := c.ConfigRelative(".")
+	if errE != nil {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(absDir, abs)
+	if err != nil {
+		return path
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// inputFile returns the path and digest of the ytt input file.
+func (c *CLI) inputFile(file *yttfiles.File) (InputFile, errors.E) {
+	data, err := file.Bytes()
+	if err != nil {
+		return InputFile{}, errors.WithDetails(err, "file", file.Description())
+	}
+
+	// Files read from disk are described by their quoted path.
+	path, ok := strings.CutPrefix(file.Description(), "file '")
+	if !ok {
+		return InputFile{Path: file.RelativePath(), Digest: digest(data), Generated: true}, nil
+	}
+
+	return InputFile{Path: c.configRelPath(strings.TrimSuffix(path, "'")), Digest: digest(data)}, nil
+}
+
+// RenderManifest returns the provenance manifest of the last render of the
+// app, which produced output. Input files are the ytt input files in order,
+// followed by the post-render overlays and patches. Values of environment
+// variables overriding the config are redacted.
+func (app *App) RenderManifest(cli *CLI, output []byte) (*RenderManifest, errors.E) {
+	manifest := &RenderManifest{
+		Version:      dyttyVersion(),
+		Revision:     dyttyRevision(),
+		GitCommit:    gitCommit(),
+		Kind:         app.BaseApp.Kind,
+		App:          app.Name,
+		Env:          app.Env.Name,
+		Cluster:      app.Cluster.Name,
+		ImageTag:     app.Image.Tag,
+		NoSecrets:    cli.NoSecrets,
+		DataValues:   app.DataValueOverrides(),
+		Files:        []InputFile{},
+		OutputDigest: digest(output),
+	}
+
+	if cli.Config != "" {
+		path, err := cli.FindConfig()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		manifest.Config = filepath.Base(path)
+		manifest.ConfigDigest = digest(data)
+	}
+
+	for _, file := range cli.configFiles {
+		manifest.ConfigFiles = append(manifest.ConfigFiles, InputFile{Path: cli.configRelPath(file.Path), Digest: file.Digest})
+	}
+
+	redactor := cli.NewRedactor(app)
+
+	for _, variable := range cli.configEnv {
+		manifest.ConfigEnv = append(manifest.ConfigEnv, redactor.Variable(variable))
+	}
+
+	postRender, err := addFiles(*yttcmd.NewOptions(), app.PostRenderPaths()...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, file := range append(append([]*yttfiles.File{}, app.InputFiles...), postRender...) {
+		input, errE := cli.inputFile(file)
+		if errE != nil {
+			return nil, errE
+		}
+
+		manifest.Files = append(manifest.Files, input)
+	}
+
+	return manifest, nil
+}
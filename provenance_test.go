@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/blakebarnett/dytty/cli"
+)
+
+func TestRenderManifest(t *testing.T) {
+	writeTestCommand(t, "git", "#!/bin/sh\necho 0123456789abcdef\n")
+
+	basePath := t.TempDir()
+	files := map[string]string{
+		".dytty.yaml":               "basePath: .\n",
+		"global/values.yaml":        "#@data/values\n---\ntemplates: [deployment.yaml]\napp:\n  image:\n    tag: \"\"\n",
+		"apps/example/values.yaml":  "#@data/values\n---\n{}\n",
+		"templates/deployment.yaml": "kind: Deployment\n",
+		"apps/example/" + AppMetadataFile: `secrets:
+  db:
+    password: db#password
+`,
+	}
+	writeTestFiles(t, basePath, files)
+
+	config := cli.ConfigFlag(basePath + "/.dytty.yaml")
+	cli := &CLI{BasePath: basePath, ImageTag: "1.2.3"}
+	cli.Config = config
+	cli.ConfigFileLoaded(basePath+"/teams.yaml", []byte("environments: {}\n"))
+	cli.ConfigFileLoaded(basePath+"/.dytty.yaml", []byte(files[".dytty.yaml"]))
+	cli.ConfigEnvApplied("DYTTY_IMAGE_TAG", "1.2.3")
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+
+	app, errE := NewApp("apps", "example", "development", cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	output, err := app.Render(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, errE := app.RenderSecrets(cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	generated, err := secrets[0].Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &RenderManifest{
+		Config:       ".dytty.yaml",
+		ConfigDigest: digest([]byte(files[".dytty.yaml"])),
+		ConfigFiles: []InputFile{
+			{Path: "teams.yaml", Digest: digest([]byte("environments: {}\n"))},
+			{Path: ".dytty.yaml", Digest: digest([]byte(files[".dytty.yaml"]))},
+		},
+		ConfigEnv:  []string{"DYTTY_IMAGE_TAG=1.2.3"},
+		GitCommit:  "0123456789abcdef",
+		Kind:       "apps",
+		App:        "example",
+		Env:        "development",
+		ImageTag:   "1.2.3",
+		DataValues: []string{"app.image.tag=1.2.3"},
+		Files: []InputFile{
+			{Path: "global/values.yaml", Digest: digest([]byte(files["global/values.yaml"]))},
+			{Path: "apps/example/values.yaml", Digest: digest([]byte(files["apps/example/values.yaml"]))},
+			{Path: "templates/deployment.yaml", Digest: digest([]byte(files["templates/deployment.yaml"]))},
+			{Path: "secrets/example.yaml", Digest: digest(generated), Generated: true},
+		},
+		OutputDigest: digest(output),
+	}
+
+	got, errE := app.RenderManifest(cli, output)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("RenderManifest(...): -want, +got:\n%s\n", diff)
+	}
+}
+
+func TestRenderCommandProvenance(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":        "#@data/values\n---\ntemplates: [deployment.yaml]\napp:\n  image:\n    tag: \"\"\n",
+		"apps/example/values.yaml":  "#@data/values\n---\n{}\n",
+		"templates/deployment.yaml": "kind: Deployment\n---\nkind: Namespace\n",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+	cli.ConfigEnvApplied("DYTTY_KINDS_APPS_VARS_API_TOKEN", "s3cr3t")
+
+	provenance := filepath.Join(basePath, RenderManifestFile)
+	command := &RenderCommand{Kind: "apps", App: "example", Env: "development", Format: FormatJSON, Sort: SortKapp, Provenance: provenance}
+
+	errE := command.Run(cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	got, errE := LoadRenderManifest(provenance)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	want := []string{FormatJSON, SortKapp, digest([]byte("{\n  \"kind\": \"Namespace\"\n}\n{\n  \"kind\": \"Deployment\"\n}\n"))}
+	if diff := cmp.Diff(want, []string{got.Format, got.Sort, got.OutputDigest}); diff != "" {
+		t.Errorf("The manifest should record the format and sort and digest the output printed: -want, +got:\n%s\n", diff)
+	}
+
+	if diff := cmp.Diff([]string{"DYTTY_KINDS_APPS_VARS_API_TOKEN=" + RedactedValue}, got.ConfigEnv); diff != "" {
+		t.Errorf("The manifest should redact environment variables: -want, +got:\n%s\n", diff)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RedactedValue replaces redacted values in output and logs.
+const RedactedValue = "<redacted>"
+
+// minRedactedLength is the length below which decrypted values are redacted only
+// as whole scalars, as short values like ports or booleans appear elsewhere too.
+const minRedactedLength = 6
+
+// DefaultRedactedKeys are used when no key patterns are configured.
+//
+//nolint:gochecknoglobals
+var DefaultRedactedKeys = []string{"*password*", "*secret*", "*token*"}
+
+type Redaction struct {
+	// Keys are case-insensitive patterns of dot-separated data value keys,
+	// where * matches any characters, e.g. *password* or *.secret.*.
+	Keys []string `yaml:"keys"`
+}
+
+// Redactor redacts data values with keys matching patterns and any value
+// decrypted from encrypted files.
+type Redactor struct {
+	keys []*regexp.Regexp
+	// secrets are redacted wherever they appear.
+	secrets []string
+	// scalars are all decrypted values, redacted as whole values.
+	scalars map[string]bool
+	// shortScalars match short decrypted values as whole YAML scalars in text.
+	shortScalars []*regexp.Regexp
+}
+
+// NewRedactor returns a redactor for the configured key patterns and the
+// values decrypted for the app.
+func (cli *CLI) NewRedactor(app *App) *Redactor {
+	patterns := cli.Redact.Keys
+	if patterns == nil {
+		patterns = DefaultRedactedKeys
+	}
+
+	r := &Redactor{scalars: map[string]bool{}}
+
+	for _, pattern := range patterns {
+		expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+		r.keys = append(r.keys, regexp.MustCompile("(?i)^"+expr+"$"))
+	}
+
+	for _, secret := range app.DecryptedValues {
+		if secret == "" || r.scalars[secret] {
+			continue
+		}
+
+		r.scalars[secret] = true
+
+		if len(secret) < minRedactedLength {
+			expr := `(?m)((?:^|:|-)[ \t]+["']?)` + regexp.QuoteMeta(secret) + `(["']?[ \t]*$)`
+			r.shortScalars = append(r.shortScalars, regexp.MustCompile(expr))
+		} else {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+
+	// Longer secrets first, so secrets containing others are fully redacted.
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+
+	return r
+}
+
+func (r *Redactor) redactedKey(key string) bool {
+	for _, expr := range r.keys {
+		if expr.MatchString(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns s with all decrypted values replaced. Short decrypted values
+// are replaced only where they are a whole YAML scalar, e.g. "port: 5432".
+func (r *Redactor) String(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, RedactedValue)
+	}
+
+	for _, expr := range r.shortScalars {
+		s = expr.ReplaceAllString(s, "${1}"+RedactedValue+"${2}")
+	}
+
+	return s
+}
+
+// Variable returns the environment variable name=value with the value redacted
+// if the lowercase name matches a key pattern or the value was decrypted, and
+// otherwise with decrypted values replaced.
+func (r *Redactor) Variable(variable string) string {
+	name, value, _ := strings.Cut(variable, "=")
+	if r.redactedKey(strings.ToLower(name)) || r.scalars[value] {
+		return name + "=" + RedactedValue
+	}
+
+	return name + "=" + r.String(value)
+}
+
+// Values returns a copy of the data values with values of matching keys and
+// decrypted values replaced.
+func (r *Redactor) Values(values map[string]any) map[string]any {
+	redacted, _ := r.value("", values).(map[string]any)
+
+	return redacted
+}
+
+func (r *Redactor) value(key string, value any) any {
+	if key != "" && r.redactedKey(key) {
+		return RedactedValue
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+
+		for k, item := range v {
+			path := k
+			if key != "" {
+				path = key + "." + k
+			}
+
+			result[k] = r.value(path, item)
+		}
+
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+
+		for _, item := range v {
+			result = append(result, r.value(key, item))
+		}
+
+		return result
+	case string:
+		if r.scalars[v] {
+			return RedactedValue
+		}
+
+		return r.String(v)
+	default:
+		if v != nil && r.scalars[fmt.Sprint(v)] {
+			return RedactedValue
+		}
+
+		return v
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yttfiles "carvel.dev/ytt/pkg/files"
+	"gitlab.com/tozd/go/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	SecretTypeExternal = "external"
+	SecretTypeSealed   = "sealed"
+)
+
+// DefaultSealedLockFile is the lock file of sealed values used when none is
+// configured, relative to the config file.
+const DefaultSealedLockFile = "sealed.lock.yaml"
+
+// SecretsConfig configures how secrets declared by apps are generated.
+type SecretsConfig struct {
+	// Type is external for ExternalSecret or sealed for SealedSecret manifests.
+	Type string `yaml:"type"`
+	// Store, StoreKind and RefreshInterval configure ExternalSecret manifests.
+	Store           string `yaml:"store"`
+	StoreKind       string `yaml:"storeKind"`
+	RefreshInterval string `yaml:"refreshInterval"`
+	// Cert is the public certificate SealedSecret manifests are sealed with.
+	Cert string `yaml:"cert"`
+	// Namespace of generated manifests, by default none.
+	Namespace string `yaml:"namespace"`
+	// Lock is the file sealed values are recorded in, by default
+	// sealed.lock.yaml next to the config file.
+	Lock string `yaml:"lock"`
+}
+
+type secretMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type externalSecretRemoteRef struct {
+	Key      string `yaml:"key"`
+	Property string `yaml:"property,omitempty"`
+}
+
+type externalSecretData struct {
+	SecretKey string                  `yaml:"secretKey"`
+	RemoteRef externalSecretRemoteRef `yaml:"remoteRef"`
+}
+
+type externalSecret struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   secretMetadata `yaml:"metadata"`
+	Spec       struct {
+		RefreshInterval string `yaml:"refreshInterval,omitempty"`
+		SecretStoreRef  struct {
+			Name string `yaml:"name"`
+			Kind string `yaml:"kind,omitempty"`
+		} `yaml:"secretStoreRef"`
+		Target struct {
+			Name string `yaml:"name"`
+		} `yaml:"target"`
+		Data []externalSecretData `yaml:"data"`
+	} `yaml:"spec"`
+}
+
+type sealedSecret struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   secretMetadata `yaml:"metadata"`
+	Spec       struct {
+		EncryptedData map[string]string `yaml:"encryptedData"`
+		Template      struct {
+			Metadata secretMetadata `yaml:"metadata"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+// LockedSealedValue records a sealed value with a digest of its inputs. Inputs
+// are digested as committed, with encrypted files still encrypted, so the digest
+// does not reveal the plaintext.
+type LockedSealedValue struct {
+	Digest string `yaml:"digest"`
+	Sealed string `yaml:"sealed"`
+}
+
+// SealedLock records sealed values by kind, app, env, cluster, secret and key.
+// kubeseal encrypts with a random session key, so values are reused from the
+// lock while their inputs are unchanged to keep rendering deterministic.
+type SealedLock struct {
+	Values map[string]LockedSealedValue `yaml:"values"`
+
+	updated map[string]LockedSealedValue
+}
+
+// LoadSealedLock reads the lock file at path. A missing file results in an empty lock.
+func LoadSealedLock(path string) (*SealedLock, errors.E) {
+	lock := &SealedLock{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		lock.Values = map[string]LockedSealedValue{}
+
+		return lock, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(lock)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.WithDetails(err, "path", path)
+	}
+
+	if lock.Values == nil {
+		lock.Values = map[string]LockedSealedValue{}
+	}
+
+	return lock, nil
+}
+
+// set records a sealed value under key, to be written by Save.
+func (l *SealedLock) set(key string, value LockedSealedValue) {
+	if l.updated == nil {
+		l.updated = map[string]LockedSealedValue{}
+	}
+
+	l.Values[key] = value
+	l.updated[key] = value
+}
+
+// Save records the sealed values updated since loading in the lock file at
+// path. The file is read again and replaced at once, so values recorded by
+// others in the meantime are kept.
+func (l *SealedLock) Save(path string) errors.E {
+	if len(l.updated) == 0 {
+		return nil
+	}
+
+	current, errE := LoadSealedLock(path)
+	if errE != nil {
+		return errE
+	}
+
+	for key, value := range l.updated {
+		current.Values[key] = value
+	}
+
+	data, err := yaml.Marshal(current)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return errors.WithDetails(err, "path", path)
+	}
+	defer os.Remove(file.Name()) //nolint:errcheck
+
+	_, err = file.Write(data)
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+
+	if err == nil {
+		err = os.Chmod(file.Name(), 0o644) //nolint:gomnd,gosec
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	if err != nil {
+		return errors.WithDetails(err, "path", path)
+	}
+
+	l.updated = nil
+
+	return nil
+}
+
+// sealedDigest returns the digest of the inputs of a sealed value: the
+// certificate, the secret, the data values key holding the value and the
+// digest of the app's input files.
+func sealedDigest(cert []byte, config SecretsConfig, name, ref, inputs string) string {
+	data := []byte{}
+
+	for _, input := range [][]byte{cert, []byte(config.Namespace), []byte(name), []byte(ref), []byte(inputs)} {
+		data = append(append(data, input...), 0)
+	}
+
+	return digest(data)
+}
+
+// inputsDigest returns the digest of the ytt input files as they are
+// committed, with encrypted files still encrypted.
+func inputsDigest(files []*yttfiles.File) (string, errors.E) {
+	data := []byte{}
+
+	for _, file := range files {
+		content, err := file.Bytes()
+		if err != nil {
+			return "", errors.WithDetails(err, "file", file.Description())
+		}
+
+		data = append(append(append(data, file.RelativePath()...), 0), digest(content)...)
+	}
+
+	return digest(data), nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// newExternalSecret returns an ExternalSecret for the secret with keys mapped to
+// backend references in the form key[#property].
+func newExternalSecret(config SecretsConfig, name string, refs map[string]string) *externalSecret {
+	secret := &externalSecret{APIVersion: "external-secrets.io/v1beta1", Kind: "ExternalSecret"}
+	secret.Metadata = secretMetadata{Name: name, Namespace: config.Namespace}
+	secret.Spec.RefreshInterval = config.RefreshInterval
+	secret.Spec.SecretStoreRef.Name = config.Store
+	secret.Spec.SecretStoreRef.Kind = config.StoreKind
+	secret.Spec.Target.Name = name
+
+	for _, key := range sortedKeys(refs) {
+		remoteKey, property, _ := strings.Cut(refs[key], "#")
+		secret.Spec.Data = append(secret.Spec.Data, externalSecretData{
+			SecretKey: key,
+			RemoteRef: externalSecretRemoteRef{Key: remoteKey, Property: property},
+		})
+	}
+
+	return secret
+}
+
+// kubeseal seals value, read by kubeseal from stdin, for the secret with the
+// public certificate.
+func kubeseal(config SecretsConfig, name, value string) (string, errors.E) {
+	args := []string{"--raw", "--cert", config.Cert, "--name", name}
+	if config.Namespace != "" {
+		args = append(args, "--namespace", config.Namespace)
+	} else {
+		args = append(args, "--scope", "cluster-wide")
+	}
+
+	out, errE := commandWithInput([]byte(value), "kubeseal", args...)
+	if errE != nil {
+		return "", errE
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// newSealedSecret returns a SealedSecret for the secret with keys mapped to the
+// data values keys holding their plaintext values. Values are reused from lock
+// under prefix while their inputs, digested in inputs, are unchanged, and
+// otherwise sealed and set in lock. Without secrets values are not sealed and
+// placeholders are used instead.
+func newSealedSecret(
+	config SecretsConfig, cert []byte, name string, refs map[string]string, values map[string]any, inputs string, lock *SealedLock, prefix string, noSecrets bool,
+) (*sealedSecret, errors.E) {
+	secret := &sealedSecret{APIVersion: "bitnami.com/v1alpha1", Kind: "SealedSecret"}
+	secret.Metadata = secretMetadata{Name: name, Namespace: config.Namespace}
+
+	if config.Namespace == "" {
+		secret.Metadata.Annotations = map[string]string{"sealedsecrets.bitnami.com/cluster-wide": "true"}
+	}
+
+	secret.Spec.Template.Metadata = secret.Metadata
+	secret.Spec.EncryptedData = map[string]string{}
+
+	for _, key := range sortedKeys(refs) {
+		if noSecrets {
+			secret.Spec.EncryptedData[key] = SecretPlaceholder
+
+			continue
+		}
+
+		value, errE := dataValue(values, refs[key])
+		if errE != nil {
+			return nil, errors.WithDetails(errE, "key", key)
+		}
+
+		lockKey := prefix + "/" + name + "/" + key
+		valueDigest := sealedDigest(cert, config, name, refs[key], inputs)
+
+		locked, ok := lock.Values[lockKey]
+		if ok && locked.Digest == valueDigest {
+			secret.Spec.EncryptedData[key] = locked.Sealed
+
+			continue
+		}
+
+		sealed, errE := kubeseal(config, name, fmt.Sprint(value))
+		if errE != nil {
+			return nil, errors.WithDetails(errE, "key", key)
+		}
+
+		lock.set(lockKey, LockedSealedValue{Digest: valueDigest, Sealed: sealed})
+
+		secret.Spec.EncryptedData[key] = sealed
+	}
+
+	return secret, nil
+}
+
+// dataValue returns the data value at the dot-separated key.
+func dataValue(values map[string]any, key string) (any, errors.E) {
+	var value any = values
+
+	for _, k := range strings.Split(key, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, errors.Errorf("data value %s not found", key)
+		}
+
+		value, ok = m[k]
+		if !ok {
+			return nil, errors.Errorf("data value %s not found", key)
+		}
+	}
+
+	return value, nil
+}
+
+// sealedCertPath returns the location of the certificate values are sealed
+// with, which can also be a URL.
+func (app *App) sealedCertPath(cli *CLI) (string, errors.E) {
+	if cli.Kinds.Apps.Secrets.Cert == "" {
+		return "", nil
+	}
+
+	paths, errE := app.renderPathTemplates([]string{cli.Kinds.Apps.Secrets.Cert})
+	if errE != nil {
+		return "", errE
+	}
+
+	return paths[0], nil
+}
+
+// sealedLockPath returns the location of the lock file of sealed values.
+func (app *App) sealedLockPath(cli *CLI) (string, errors.E) {
+	if cli.Kinds.Apps.Secrets.Lock != "" {
+		paths, errE := app.renderPathTemplates([]string{cli.Kinds.Apps.Secrets.Lock})
+		if errE != nil {
+			return "", errE
+		}
+
+		return paths[0], nil
+	}
+
+	return cli.ConfigRelative(DefaultSealedLockFile)
+}
+
+// RenderSecrets returns the manifests for the secrets declared by the app as a
+// plain YAML ytt input file.
+func (app *App) RenderSecrets(cli *CLI) ([]*yttfiles.File, errors.E) {
+	files := []*yttfiles.File{}
+
+	if len(app.Metadata.Secrets) == 0 {
+		return files, nil
+	}
+
+	config := cli.Kinds.Apps.Secrets
+
+	var errE errors.E
+
+	config.Cert, errE = app.sealedCertPath(cli)
+	if errE != nil {
+		return nil, errE
+	}
+
+	var values map[string]any
+
+	var cert []byte
+
+	inputs := ""
+	lock := &SealedLock{Values: map[string]LockedSealedValue{}}
+	lockPath := ""
+
+	if config.Type == SecretTypeSealed && !cli.NoSecrets {
+		var err error
+
+		values, err = ParseValues(app, cli)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// The certificate can also be a URL, then its location is digested instead.
+		cert, err = os.ReadFile(config.Cert)
+		if err != nil {
+			cert = []byte(config.Cert)
+		}
+
+		inputs, errE = inputsDigest(app.InputFiles)
+		if errE != nil {
+			return nil, errE
+		}
+
+		lockPath, errE = app.sealedLockPath(cli)
+		if errE != nil {
+			return nil, errE
+		}
+
+		lock, errE = LoadSealedLock(lockPath)
+		if errE != nil {
+			return nil, errE
+		}
+	}
+
+	prefix := strings.Join([]string{app.Kind, app.Name, app.Env.Name}, "/")
+	if cli.Cluster != "" {
+		prefix += "/" + cli.Cluster
+	}
+
+	docs := []string{}
+
+	for _, name := range sortedKeys(app.Metadata.Secrets) {
+		refs := map[string]string{}
+
+		for key, ref := range app.Metadata.Secrets[name] {
+			paths, errE := app.renderPathTemplates([]string{ref})
+			if errE != nil {
+				return nil, errors.WithDetails(errE, "secret", name, "key", key)
+			}
+
+			refs[key] = paths[0]
+		}
+
+		var manifest any
+
+		switch config.Type {
+		case "", SecretTypeExternal:
+			manifest = newExternalSecret(config, name, refs)
+		case SecretTypeSealed:
+			manifest, errE = newSealedSecret(config, cert, name, refs, values, inputs, lock, prefix, cli.NoSecrets)
+			if errE != nil {
+				return nil, errors.WithDetails(errE, "secret", name)
+			}
+		default:
+			return nil, errors.Errorf("unknown secrets type: %s", config.Type)
+		}
+
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		docs = append(docs, string(data))
+	}
+
+	// Rendering only reads the lock, as renders can run concurrently.
+	if cli.UpdateSealed {
+		errE = lock.Save(lockPath)
+		if errE != nil {
+			return nil, errE
+		}
+	} else if len(lock.updated) > 0 {
+		cli.GetLoggingConfig().Logger.Warn().Msgf(
+			"%d sealed values of %s are not recorded in %s, run with --update-sealed to record them", len(lock.updated), prefix, lockPath,
+		)
+	}
+
+	file, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource("secrets/"+app.Name+".yaml", []byte(strings.Join(docs, "---\n"))))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	file.MarkTemplate(false)
+
+	return append(files, file), nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/google/go-cmp/cmp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/zerolog"
+)
+
+// fakeKubeseal "seals" its input by prefixing it with the secret name.
+const fakeKubeseal = `#!/bin/sh
+printf 'sealed-%s-' "$5"
+cat
+`
+
+// countingKubeseal "seals" its input with a different prefix on every call, like
+// kubeseal does with a random session key.
+const countingKubeseal = `#!/bin/sh
+n=$(cat "$0.count" 2>/dev/null || echo 0)
+n=$((n+1))
+echo "$n" > "$0.count"
+printf 'sealed-%s-' "$n"
+cat
+`
+
+func TestRenderSecrets(t *testing.T) {
+	writeTestCommand(t, "kubeseal", fakeKubeseal)
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":        "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\ndb:\n  password: hunter2\n",
+		"apps/example/values.yaml":  "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"templates/deployment.yaml": "kind: Deployment\n",
+	})
+
+	externalRefs := "secrets:\n  db:\n    password: \"{{.Env.Name}}/db#password\"\n    user: \"{{.Env.Name}}/db-user\"\n"
+	sealedRefs := "secrets:\n  db:\n    password: db.password\n"
+	lock := filepath.Join(basePath, DefaultSealedLockFile)
+
+	cases := map[string]struct {
+		reason    string
+		config    SecretsConfig
+		metadata  string
+		noSecrets bool
+		want      string
+	}{
+		"External": {
+			reason:   "Declared secrets should be rendered as ExternalSecret manifests",
+			config:   SecretsConfig{Store: "vault", StoreKind: "ClusterSecretStore", RefreshInterval: "1h"},
+			metadata: externalRefs,
+			want: `kind: Deployment
+---
+apiVersion: external-secrets.io/v1beta1
+kind: ExternalSecret
+metadata:
+  name: db
+spec:
+  refreshInterval: 1h
+  secretStoreRef:
+    name: vault
+    kind: ClusterSecretStore
+  target:
+    name: db
+  data:
+  - secretKey: password
+    remoteRef:
+      key: development/db
+      property: password
+  - secretKey: user
+    remoteRef:
+      key: development/db-user
+`,
+		},
+		"Sealed": {
+			reason:   "Declared secrets should be sealed from data values",
+			config:   SecretsConfig{Type: SecretTypeSealed, Cert: "cert.pem", Namespace: "default", Lock: lock},
+			metadata: sealedRefs,
+			want: `kind: Deployment
+---
+apiVersion: bitnami.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: db
+  namespace: default
+spec:
+  encryptedData:
+    password: sealed-db-hunter2
+  template:
+    metadata:
+      name: db
+      namespace: default
+`,
+		},
+		"SealedNoSecrets": {
+			reason:    "Without secrets sealed secrets should use placeholders",
+			config:    SecretsConfig{Type: SecretTypeSealed, Cert: "cert.pem", Lock: lock},
+			metadata:  sealedRefs,
+			noSecrets: true,
+			want: `kind: Deployment
+---
+apiVersion: bitnami.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: db
+  annotations:
+    sealedsecrets.bitnami.com/cluster-wide: "true"
+spec:
+  encryptedData:
+    password: ` + SecretPlaceholder + `
+  template:
+    metadata:
+      name: db
+      annotations:
+        sealedsecrets.bitnami.com/cluster-wide: "true"
+`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			writeTestFiles(t, basePath, map[string]string{"apps/example/" + AppMetadataFile: tc.metadata})
+
+			cli := &CLI{BasePath: basePath, NoSecrets: tc.noSecrets}
+			cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+			cli.Kinds.Apps.Secrets = tc.config
+
+			app, errE := NewApp("apps", "example", "development", cli)
+			if errE != nil {
+				t.Fatal(errE)
+			}
+
+			got, err := app.Render(cli)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("\n%s\nRender(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRenderSealedSecretsLock(t *testing.T) {
+	writeTestCommand(t, "kubeseal", countingKubeseal)
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":              "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\ndb:\n  password: hunter2\n",
+		"apps/example/values.yaml":        "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"apps/example/" + AppMetadataFile: "secrets:\n  db:\n    password: db.password\n",
+		"templates/deployment.yaml":       "kind: Deployment\n",
+		"cert.pem":                        "cert",
+	})
+
+	lock := filepath.Join(basePath, DefaultSealedLockFile)
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+	cli.Kinds.Apps.Secrets = SecretsConfig{Type: SecretTypeSealed, Cert: filepath.Join(basePath, "cert.pem"), Lock: lock}
+
+	render := func() string {
+		t.Helper()
+
+		app, errE := NewApp("apps", "example", "development", cli)
+		if errE != nil {
+			t.Fatal(errE)
+		}
+
+		got, err := app.Render(cli)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(got)
+	}
+
+	if got := render(); !strings.Contains(got, "password: sealed-1-hunter2") {
+		t.Errorf("expected the value to be sealed, got:\n%s", got)
+	}
+
+	if _, err := os.Stat(lock); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the lock file not to be written without --update-sealed, got: %v", err)
+	}
+
+	cli.UpdateSealed = true
+
+	first := render()
+	if !strings.Contains(first, "password: sealed-2-hunter2") {
+		t.Errorf("expected the value to be sealed, got:\n%s", first)
+	}
+
+	data, err := os.ReadFile(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), "digest: sha256:") {
+		t.Errorf("unexpected lock file:\n%s", data)
+	}
+
+	cli.UpdateSealed = false
+
+	if diff := cmp.Diff(first, render()); diff != "" {
+		t.Errorf("expected unchanged values to be reused: -want, +got:\n%s\n", diff)
+	}
+
+	writeTestFiles(t, basePath, map[string]string{"cert.pem": "rotated"})
+
+	if got := render(); !strings.Contains(got, "password: sealed-3-hunter2") {
+		t.Errorf("expected the value to be sealed again with a changed certificate, got:\n%s", got)
+	}
+
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml": "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\ndb:\n  password: changed\n",
+	})
+
+	if got := render(); !strings.Contains(got, "password: sealed-4-changed") {
+		t.Errorf("expected a changed value to be sealed again, got:\n%s", got)
+	}
+}
+
+func TestSealedCertURL(t *testing.T) {
+	writeTestCommand(t, "kubeseal", "#!/bin/sh\nprintf 'sealed-%s-' \"$3\"\ncat\n")
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		".dytty.yaml":                     "basePath: .\nkinds:\n  apps:\n    paths:\n      requiredValues: [\"apps/{{.Name}}/values.yaml\"]\n    secrets:\n      type: sealed\n      cert: https://example.com/cert.pem\n",
+		"global/values.yaml":              "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\ndb:\n  password: hunter2\n",
+		"apps/example/values.yaml":        "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"apps/example/" + AppMetadataFile: "secrets:\n  db:\n    password: db.password\n",
+		"templates/deployment.yaml":       "kind: Deployment\n",
+	})
+
+	var c CLI
+
+	parser, err := kong.New(&c, kong.Vars{
+		"defaultLoggingConsoleType":             zerolog.DefaultConsoleType,
+		"defaultLoggingConsoleLevel":            "warn",
+		"defaultLoggingFileLevel":               zerolog.DefaultFileLevel,
+		"defaultLoggingMainLevel":               "warn",
+		"defaultLoggingContextLevel":            zerolog.DefaultContextLevel,
+		"defaultLoggingContextConditionalLevel": zerolog.DefaultContextConditionalLevel,
+		"defaultLoggingContextTriggerLevel":     zerolog.DefaultContextTriggerLevel,
+	}, zerolog.KongLevelTypeMapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parser.Parse([]string{"--config", filepath.Join(basePath, ".dytty.yaml"), "render", "apps", "example", "development"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff("https://example.com/cert.pem", c.Kinds.Apps.Secrets.Cert); diff != "" {
+		t.Errorf("The certificate URL should not be resolved as a path: -want, +got:\n%s\n", diff)
+	}
+
+	app, errE := NewApp("apps", "example", "development", &c)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	got, err := app.Render(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(got), "password: sealed-https://example.com/cert.pem-hunter2") {
+		t.Errorf("expected the value to be sealed with the certificate URL, got:\n%s", got)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// DefaultSnapshotsDir is the snapshots directory used when none is configured,
+// relative to the config file.
+const DefaultSnapshotsDir = "tests/snapshots"
+
+// snapshotDiffContext is the number of unchanged lines shown around changes.
+const snapshotDiffContext = 3
+
+type TestCommand struct {
+	Update    bool   `help:"Regenerate the snapshots instead of comparing with them."                                 name:"update"    yaml:"-"`
+	Snapshots string `help:"Directory with the snapshots. (default: tests/snapshots next to the config file)" name:"snapshots" placeholder:"PATH" yaml:"snapshots"`
+}
+
+// SnapshotPath returns the location of the snapshot of the target in dir.
+func SnapshotPath(dir string, target Target, cluster string) string {
+	return filepath.Join(dir, target.Env, cluster, target.Kind, target.App+".yaml")
+}
+
+// inSnapshotScope returns true if the snapshot at path, relative to the
+// snapshots directory, is of the cluster, or of no cluster if it is empty.
+func inSnapshotScope(path string, cluster string) bool {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	if cluster == "" {
+		return len(parts) == 3 //nolint:gomnd
+	}
+
+	return len(parts) == 4 && parts[1] == cluster //nolint:gomnd
+}
+
+func (c *TestCommand) snapshotsDir(cli *CLI) (string, errors.E) {
+	if c.Snapshots != "" {
+		return c.Snapshots, nil
+	}
+
+	return cli.ConfigRelative(DefaultSnapshotsDir)
+}
+
+// diffLines returns the differing lines of a and b, prefixed with - and +
+// respectively, with unchanged lines around them for context.
+func diffLines(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	removed, added := make([]bool, len(x)), make([]bool, len(y))
+	markChanges(x, y, removed, added)
+
+	lines := []string{}
+	changed := []bool{}
+
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && removed[i]:
+			lines = append(lines, "- "+x[i])
+			changed = append(changed, true)
+			i++
+		case j < len(y) && added[j]:
+			lines = append(lines, "+ "+y[j])
+			changed = append(changed, true)
+			j++
+		default:
+			lines = append(lines, "  "+x[i])
+			changed = append(changed, false)
+			i++
+			j++
+		}
+	}
+
+	var buf strings.Builder
+
+	skipped := false
+
+	for k, line := range lines {
+		show := false
+
+		for l := max(0, k-snapshotDiffContext); l <= min(len(lines)-1, k+snapshotDiffContext); l++ {
+			show = show || changed[l]
+		}
+
+		if !show {
+			skipped = true
+
+			continue
+		}
+
+		if skipped && buf.Len() > 0 {
+			buf.WriteString("  ...\n")
+		}
+
+		skipped = false
+
+		buf.WriteString(line + "\n")
+	}
+
+	return buf.String()
+}
+
+// markChanges marks the lines of x removed and the lines of y added by a
+// shortest edit script from x to y. It uses the linear space variant of Myers'
+// diff algorithm, splitting at a middle snake, so large snapshots can be diffed.
+func markChanges(x, y []string, removed, added []bool) {
+	for len(x) > 0 && len(y) > 0 && x[0] == y[0] {
+		x, y, removed, added = x[1:], y[1:], removed[1:], added[1:]
+	}
+
+	for len(x) > 0 && len(y) > 0 && x[len(x)-1] == y[len(y)-1] {
+		x, y, removed, added = x[:len(x)-1], y[:len(y)-1], removed[:len(removed)-1], added[:len(added)-1]
+	}
+
+	if len(x) == 0 || len(y) == 0 {
+		for i := range removed {
+			removed[i] = true
+		}
+
+		for j := range added {
+			added[j] = true
+		}
+
+		return
+	}
+
+	i, j, ok := middleSnake(x, y)
+	if !ok {
+		markChanges(x, nil, removed, nil)
+		markChanges(nil, y, nil, added)
+
+		return
+	}
+
+	markChanges(x[:i], y[:j], removed[:i], added[:j])
+	markChanges(x[i:], y[j:], removed[i:], added[j:])
+}
+
+// middleSnake returns where the paths of a shortest edit script from x to y
+// searched from both ends overlap, or false if x and y have nothing in common.
+func middleSnake(x, y []string) (int, int, bool) {
+	n, m := len(x), len(y)
+	maxD := (n + m + 1) / 2 //nolint:gomnd
+	offset := maxD + 1
+	forward, backward := make([]int, 2*offset+1), make([]int, 2*offset+1) //nolint:gomnd
+
+	for k := range forward {
+		forward[k], backward[k] = -1, -1
+	}
+
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	odd := delta%2 != 0 //nolint:gomnd
+
+	// Diagonals which left the edit graph are not searched anymore.
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+
+	for d := 0; d < maxD; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			var i int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				i = forward[offset+k+1]
+			} else {
+				i = forward[offset+k-1] + 1
+			}
+
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+
+			forward[offset+k] = i
+
+			switch {
+			case i > n:
+				fEnd += 2
+			case j > m:
+				fStart += 2
+			case odd:
+				bk := offset + delta - k
+				if bk >= 0 && bk < len(backward) && backward[bk] != -1 && i >= n-backward[bk] {
+					return i, j, true
+				}
+			}
+		}
+
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			var i int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				i = backward[offset+k+1]
+			} else {
+				i = backward[offset+k-1] + 1
+			}
+
+			j := i - k
+			for i < n && j < m && x[n-i-1] == y[m-j-1] {
+				i++
+				j++
+			}
+
+			backward[offset+k] = i
+
+			switch {
+			case i > n:
+				bEnd += 2
+			case j > m:
+				bStart += 2
+			case !odd:
+				fk := offset + delta - k
+				if fk >= 0 && fk < len(forward) && forward[fk] != -1 && forward[fk] >= n-i {
+					return forward[fk], forward[fk] - (delta - k), true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+func (c *TestCommand) Run(cli *CLI) errors.E {
+	logger := cli.GetLoggingConfig().Logger
+
+	dir, errE := c.snapshotsDir(cli)
+	if errE != nil {
+		return errE
+	}
+
+	logger.Info().Msgf("Testing snapshots in: %s", dir)
+
+	// Snapshots are committed, so they are rendered with placeholders instead of
+	// decrypted secrets and without the git commit.
+	cli.NoSecrets = true
+	cli.snapshot = true
+
+	targets, errE := cli.Targets()
+	if errE != nil {
+		return errE
+	}
+
+	failed := 0
+	snapshots := map[string]bool{}
+
+	for _, target := range targets {
+		path := SnapshotPath(dir, target, cli.Cluster)
+		snapshots[path] = true
+		name := strings.Join([]string{target.Env, target.Kind, target.App}, "/")
+
+		app, errE := NewApp(target.Kind, target.App, target.Env, cli)
+		if errE != nil {
+			failed++
+
+			_, _ = fmt.Fprintf(os.Stdout, "ERROR    %s: %s\n", name, errE)
+
+			continue
+		}
+
+		got, err := app.Render(cli)
+		if err != nil {
+			failed++
+
+			_, _ = fmt.Fprintf(os.Stdout, "ERROR    %s: %s\n", name, cli.NewRedactor(app).String(err.Error()))
+
+			continue
+		}
+
+		want, err := os.ReadFile(path)
+
+		switch {
+		case c.Update && err == nil && bytes.Equal(want, got):
+			_, _ = fmt.Fprintf(os.Stdout, "ok       %s\n", name)
+		case c.Update:
+			err = os.MkdirAll(filepath.Dir(path), 0o755) //nolint:gomnd
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			err = os.WriteFile(path, got, 0o644) //nolint:gomnd,gosec
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			_, _ = fmt.Fprintf(os.Stdout, "updated  %s\n", name)
+		case errors.Is(err, os.ErrNotExist):
+			failed++
+
+			_, _ = fmt.Fprintf(os.Stdout, "missing  %s\n", name)
+		case err != nil:
+			return errors.WithStack(err)
+		case !bytes.Equal(want, got):
+			failed++
+
+			diff := diffLines(string(want), string(got))
+			_, _ = fmt.Fprintf(os.Stdout, "changed  %s\n%s", name, cli.NewRedactor(app).String(diff))
+		default:
+			_, _ = fmt.Fprintf(os.Stdout, "ok       %s\n", name)
+		}
+	}
+
+	// Snapshots of targets which do not exist anymore, only of the cluster tested.
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) && path == dir {
+			return filepath.SkipDir
+		} else if err != nil || d.IsDir() || filepath.Ext(path) != ".yaml" || snapshots[path] {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || !inSnapshotScope(rel, cli.Cluster) {
+			return err
+		}
+
+		if c.Update {
+			_, _ = fmt.Fprintf(os.Stdout, "removed  %s\n", path)
+
+			return os.Remove(path)
+		}
+
+		failed++
+
+		_, _ = fmt.Fprintf(os.Stdout, "obsolete %s\n", path)
+
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d snapshots do not match, run with --update to regenerate them", failed)
+	}
+
+	return nil
+}
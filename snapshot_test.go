@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffLines(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		a      string
+		b      string
+		want   string
+	}{
+		"Equal": {
+			reason: "Equal inputs should have no diff",
+			a:      "a\nb\n",
+			b:      "a\nb\n",
+			want:   "",
+		},
+		"Changed": {
+			reason: "Changed lines should be shown as removed and added",
+			a:      "a\nb\nc\n",
+			b:      "a\nx\nc\n",
+			want:   "  a\n- b\n+ x\n  c\n  \n",
+		},
+		"Context": {
+			reason: "Unchanged lines far from changes should be skipped",
+			a:      "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:      "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			want:   "  7\n  8\n  9\n+ 10\n  \n",
+		},
+		"Gap": {
+			reason: "Gaps between changes should be marked",
+			a:      "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			b:      "x\n1\n2\n3\n4\n5\n6\n7\n8\ny\n",
+			want:   "- a\n+ x\n  1\n  2\n  3\n  ...\n  6\n  7\n  8\n- b\n+ y\n  \n",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := diffLines(tc.a, tc.b)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\ndiffLines(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	var a, b strings.Builder
+
+	for i := 0; i < 50000; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		a.WriteString(line)
+
+		switch i {
+		case 20000:
+			b.WriteString("changed\n")
+		case 40000:
+			b.WriteString("added\n")
+			b.WriteString(line)
+		default:
+			b.WriteString(line)
+		}
+	}
+
+	want := "  line 19997\n  line 19998\n  line 19999\n- line 20000\n+ changed\n  line 20001\n  line 20002\n  line 20003\n" +
+		"  ...\n  line 39997\n  line 39998\n  line 39999\n+ added\n  line 40000\n  line 40001\n  line 40002\n"
+
+	got := diffLines(a.String(), b.String())
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diffLines(...) should diff large inputs: -want, +got:\n%s\n", diff)
+	}
+}
+
+func TestTestCommand(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":              "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\n",
+		"apps/example/values.yaml":        "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"apps/example/" + AppMetadataFile: "environments: [dev]\n",
+		"templates/deployment.yaml":       "kind: Deployment\n",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+
+	snapshots := filepath.Join(basePath, DefaultSnapshotsDir)
+	snapshot := SnapshotPath(snapshots, Target{Kind: "apps", App: "example", Env: "development"}, "")
+	obsolete := SnapshotPath(snapshots, Target{Kind: "apps", App: "removed", Env: "development"}, "")
+
+	command := &TestCommand{Snapshots: snapshots}
+
+	if err := command.Run(cli); err == nil {
+		t.Fatal("expected missing snapshot to fail")
+	}
+
+	clusterSnapshot := SnapshotPath(snapshots, Target{Kind: "apps", App: "example", Env: "development"}, "us-east-1")
+
+	writeTestFiles(t, basePath, map[string]string{
+		filepath.Join(DefaultSnapshotsDir, "development", "apps", "removed.yaml"):              "",
+		filepath.Join(DefaultSnapshotsDir, "development", "us-east-1", "apps", "example.yaml"): "kind: Deployment\n",
+	})
+
+	command.Update = true
+	if err := command.Run(cli); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff("kind: Deployment\n", string(got)); diff != "" {
+		t.Errorf("snapshot: -want, +got:\n%s\n", diff)
+	}
+
+	if _, err := os.Stat(obsolete); !os.IsNotExist(err) {
+		t.Errorf("expected obsolete snapshot to be removed, got %v", err)
+	}
+
+	if _, err := os.Stat(clusterSnapshot); err != nil {
+		t.Errorf("expected snapshot of another cluster to be kept, got %v", err)
+	}
+
+	command.Update = false
+	if err := command.Run(cli); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, basePath, map[string]string{"templates/deployment.yaml": "kind: StatefulSet\n"})
+
+	if err := command.Run(cli); err == nil {
+		t.Fatal("expected changed snapshot to fail")
+	}
+
+	writeTestFiles(t, basePath, map[string]string{"templates/deployment.yaml": "kind: Deployment\n"})
+
+	cli.Clusters = map[string]Cluster{"us-east-1": {}}
+	cli.Cluster = "us-east-1"
+
+	if err := command.Run(cli); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(snapshot); err != nil {
+		t.Errorf("expected snapshot without cluster to be kept, got %v", err)
+	}
+}
+
+func TestTestCommandGitCommit(t *testing.T) {
+	writeTestCommand(t, "git", "#!/bin/sh\necho 0123456789abcdef\n")
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":        "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\n",
+		"apps/example/values.yaml":  "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"templates/deployment.yaml": "kind: Deployment\n",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+	cli.Kinds.Apps.Labels = StandardLabels{
+		Enabled:     true,
+		Labels:      map[string]string{},
+		Annotations: map[string]string{"dytty.io/git-commit": "{{.GitCommit}}"},
+	}
+
+	snapshots := filepath.Join(basePath, DefaultSnapshotsDir)
+
+	errE := (&TestCommand{Snapshots: snapshots, Update: true}).Run(cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	got, err := os.ReadFile(SnapshotPath(snapshots, Target{Kind: "apps", App: "example", Env: "development"}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff("kind: Deployment\n", string(got)); diff != "" {
+		t.Errorf("snapshots should be rendered without the git commit: -want, +got:\n%s\n", diff)
+	}
+}
+
+func TestTestCommandSecrets(t *testing.T) {
+	writeTestCommand(t, "sops", fakeSOPS)
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":        "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\ndb:\n  password: \"\"\n  port: 5432\n  user: \"\"\n",
+		"apps/example/values.yaml":  "#@data/values\n---\ntemplates: [secret.yaml]\n",
+		"apps/example/secrets.yaml": encryptedValues,
+		"templates/secret.yaml":     "#@ load(\"@ytt:data\", \"data\")\nkind: Secret\nstringData:\n  password: #@ data.values.db.password\n",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{
+		basePath + "/apps/{{.Name}}/values.yaml",
+		basePath + "/apps/{{.Name}}/secrets.yaml",
+	}
+
+	snapshots := filepath.Join(basePath, DefaultSnapshotsDir)
+	command := &TestCommand{Snapshots: snapshots, Update: true}
+
+	if err := command.Run(cli); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(SnapshotPath(snapshots, Target{Kind: "apps", App: "example", Env: "development"}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "kind: Secret\nstringData:\n  password: " + SecretPlaceholder + "\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("snapshot: -want, +got:\n%s\n", diff)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	yttfiles "carvel.dev/ytt/pkg/files"
+	"gitlab.com/tozd/go/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// SecretPlaceholder replaces encrypted values when rendering without secrets.
+const SecretPlaceholder = "dytty-secret-placeholder"
+
+// encryptedValue matches a value encrypted by SOPS and captures its type.
+//
+//nolint:gochecknoglobals
+var encryptedValue = regexp.MustCompile(`^ENC\[[A-Z0-9_]+,data:.*,type:([a-z]+)\]$`)
+
+// isSOPSEncrypted returns true if the YAML data has SOPS metadata.
+func isSOPSEncrypted(data []byte) bool {
+	if !bytes.Contains(data, []byte("sops:")) {
+		return false
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var doc struct {
+			SOPS struct {
+				MAC string `yaml:"mac"`
+			} `yaml:"sops"`
+		}
+
+		err := decoder.Decode(&doc)
+		if err != nil {
+			return false
+		}
+
+		if doc.SOPS.MAC != "" {
+			return true
+		}
+	}
+}
+
+// sopsDecrypt decrypts the SOPS encrypted YAML data in memory with the keys
+// available to sops. Without a file name, sops reads the data from stdin.
+func sopsDecrypt(data []byte) ([]byte, errors.E) {
+	return commandWithInput(data, "sops", "decrypt", "--input-type", "yaml", "--output-type", "yaml")
+}
+
+// placeholderNode replaces encrypted values in node with placeholders of the
+// same type and drops encrypted comments.
+func placeholderNode(node *yaml.Node) {
+	for _, comment := range []*string{&node.HeadComment, &node.LineComment, &node.FootComment} {
+		lines := []string{}
+
+		for _, line := range strings.Split(*comment, "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "#ENC[") {
+				lines = append(lines, line)
+			}
+		}
+
+		*comment = strings.TrimSpace(strings.Join(lines, "\n"))
+	}
+
+	if node.Kind == yaml.ScalarNode {
+		if match := encryptedValue.FindStringSubmatch(node.Value); match != nil {
+			node.Style = 0
+
+			switch match[1] {
+			case "int":
+				node.Tag, node.Value = "!!int", "0"
+			case "float":
+				node.Tag, node.Value = "!!float", "0.0"
+			case "bool":
+				node.Tag, node.Value = "!!bool", "false"
+			default:
+				node.Tag, node.Value = "!!str", SecretPlaceholder
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		placeholderNode(child)
+	}
+}
+
+// sopsPlaceholders returns the SOPS encrypted YAML data without its SOPS metadata
+// and with placeholders instead of encrypted values. As the data values
+// annotation is usually encrypted with the other comments, documents without
+// annotations are annotated as data values.
+func sopsPlaceholders(data []byte) ([]byte, errors.E) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	out := []string{}
+
+	for {
+		var doc yaml.Node
+
+		err := decoder.Decode(&doc)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, errors.WithStack(err)
+		}
+
+		placeholderNode(&doc)
+
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		root := doc.Content[0]
+
+		if root.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(root.Content); i += 2 {
+				if root.Content[i].Value == "sops" {
+					root.Content = append(root.Content[:i], root.Content[i+2:]...)
+
+					break
+				}
+			}
+		}
+
+		// Annotations are written before the document start, where ytt expects them.
+		annotations := []string{}
+		heads := []*yaml.Node{&doc, root}
+
+		if len(root.Content) > 0 {
+			heads = append(heads, root.Content[0])
+		}
+
+		// yaml.v3 attaches comments before the document start to the first key,
+		// which keeps its own annotations.
+		for i, node := range heads {
+			prefix, kept := "#@", []string{}
+			if i == 2 { //nolint:gomnd
+				prefix = "#@data/"
+			}
+
+			for _, line := range strings.Split(node.HeadComment, "\n") {
+				if strings.HasPrefix(strings.TrimSpace(line), prefix) {
+					annotations = append(annotations, strings.TrimSpace(line))
+				} else if strings.HasPrefix(strings.TrimSpace(line), "#@") {
+					kept = append(kept, line)
+				}
+			}
+
+			node.HeadComment = strings.Join(kept, "\n")
+		}
+
+		if len(annotations) == 0 {
+			annotations = append(annotations, "#@data/values")
+		}
+
+		encoded, err := yaml.Marshal(root)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		out = append(out, strings.Join(annotations, "\n")+"\n---\n"+string(encoded))
+	}
+
+	return []byte(strings.Join(out, "")), nil
+}
+
+// sopsDecryptedValues returns the values in the decrypted data which are
+// encrypted in the encrypted data.
+func sopsDecryptedValues(encrypted, decrypted []byte) []string {
+	values := []string{}
+	encryptedDecoder := yaml.NewDecoder(bytes.NewReader(encrypted))
+	decryptedDecoder := yaml.NewDecoder(bytes.NewReader(decrypted))
+
+	for {
+		var encryptedDoc, decryptedDoc yaml.Node
+
+		if encryptedDecoder.Decode(&encryptedDoc) != nil || decryptedDecoder.Decode(&decryptedDoc) != nil {
+			return values
+		}
+
+		values = append(values, decryptedLeaves(&encryptedDoc, &decryptedDoc)...)
+	}
+}
+
+// decryptedLeaves returns the decrypted values of the values which are
+// encrypted in the SOPS encrypted node.
+func decryptedLeaves(encrypted, decrypted *yaml.Node) []string {
+	values := []string{}
+
+	if encrypted == nil || decrypted == nil {
+		return values
+	}
+
+	switch encrypted.Kind { //nolint:exhaustive
+	case yaml.ScalarNode:
+		if encryptedValue.MatchString(encrypted.Value) {
+			values = append(values, decrypted.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(encrypted.Content); i += 2 {
+			key := encrypted.Content[i].Value
+
+			for j := 0; j+1 < len(decrypted.Content); j += 2 {
+				if decrypted.Content[j].Value == key {
+					values = append(values, decryptedLeaves(encrypted.Content[i+1], decrypted.Content[j+1])...)
+				}
+			}
+		}
+	default:
+		for i := range encrypted.Content {
+			if i < len(decrypted.Content) {
+				values = append(values, decryptedLeaves(encrypted.Content[i], decrypted.Content[i])...)
+			}
+		}
+	}
+
+	return values
+}
+
+// decryptFiles replaces SOPS encrypted YAML files with their decrypted content,
+// or with placeholders for encrypted values when noSecrets is set, and returns
+// the decrypted values. Decrypted content is only kept in memory, in decrypted
+// by encrypted content, so that files are decrypted once even when ytt runs
+// multiple times.
+func decryptFiles(files []*yttfiles.File, noSecrets bool, decrypted map[string][]byte) ([]*yttfiles.File, []string, errors.E) {
+	result := []*yttfiles.File{}
+	decryptedValues := []string{}
+
+	for _, file := range files {
+		if file.Type() != yttfiles.TypeYAML {
+			result = append(result, file)
+
+			continue
+		}
+
+		data, err := file.Bytes()
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+
+		if !isSOPSEncrypted(data) {
+			result = append(result, file)
+
+			continue
+		}
+
+		var plain []byte
+
+		var errE errors.E
+
+		if noSecrets {
+			plain, errE = sopsPlaceholders(data)
+		} else {
+			var ok bool
+
+			plain, ok = decrypted[string(data)]
+			if !ok {
+				plain, errE = sopsDecrypt(data)
+			}
+
+			if errE == nil {
+				decrypted[string(data)] = plain
+				decryptedValues = append(decryptedValues, sopsDecryptedValues(data, plain)...)
+			}
+		}
+
+		if errE != nil {
+			return nil, nil, errors.WithDetails(errE, "file", file.Description())
+		}
+
+		decryptedFile, err := yttfiles.NewFileFromSource(yttfiles.NewBytesSource(file.RelativePath(), plain))
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+
+		result = append(result, decryptedFile)
+	}
+
+	return yttfiles.NewSortedFiles(result), decryptedValues, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeSOPS "decrypts" by replacing encrypted values and dropping the SOPS metadata.
+const fakeSOPS = `#!/bin/sh
+sed -e 's/ENC\[[^]]*\]/s3cret/' -e '/^sops:/,$d'
+`
+
+const encryptedValues = `#@data/values
+---
+db:
+    password: ENC[AES256_GCM,data:abc=,iv:x=,tag:y=,type:str]
+    port: ENC[AES256_GCM,data:MTIz,iv:x=,tag:y=,type:int]
+    user: app
+sops:
+    age:
+        - recipient: age1qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqq
+    lastmodified: "2024-01-01T00:00:00Z"
+    mac: ENC[AES256_GCM,data:zz,iv:x=,tag:y=,type:str]
+    version: 3.8.1
+`
+
+func TestEncryptedValues(t *testing.T) {
+	writeTestCommand(t, "sops", fakeSOPS)
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":        "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\ndb:\n  password: \"\"\n  port: 5432\n  user: \"\"\n",
+		"apps/example/values.yaml":  "#@data/values\n---\ntemplates: [secret.yaml]\n",
+		"apps/example/secrets.yaml": encryptedValues,
+		"templates/secret.yaml":     "#@ load(\"@ytt:data\", \"data\")\nkind: Secret\nstringData:\n  password: #@ data.values.db.password\n  user: #@ data.values.db.user\n  port: #@ str(data.values.db.port)\n",
+	})
+
+	cases := map[string]struct {
+		reason    string
+		noSecrets bool
+		want      string
+	}{
+		"Decrypted": {
+			reason: "Encrypted values files should be decrypted before rendering",
+			want:   "kind: Secret\nstringData:\n  password: s3cret\n  user: app\n  port: s3cret\n",
+		},
+		"NoSecrets": {
+			reason:    "Encrypted values should be replaced with placeholders without secrets",
+			noSecrets: true,
+			want:      "kind: Secret\nstringData:\n  password: " + SecretPlaceholder + "\n  user: app\n  port: \"0\"\n",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cli := &CLI{BasePath: basePath, NoSecrets: tc.noSecrets}
+			cli.Kinds.Apps.Paths.RequiredValues = []string{
+				basePath + "/apps/{{.Name}}/values.yaml",
+				basePath + "/apps/{{.Name}}/secrets.yaml",
+			}
+
+			app, errE := NewApp("apps", "example", "development", cli)
+			if errE != nil {
+				t.Fatal(errE)
+			}
+
+			got, err := app.Render(cli)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("\n%s\nRender(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestEncryptedValuesDecryptedOnce(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "sops.log")
+	t.Setenv("DYTTY_TEST_SOPS_LOG", log)
+	writeTestCommand(t, "sops", "#!/bin/sh\necho \"$@\" >> \"$DYTTY_TEST_SOPS_LOG\"\n"+strings.TrimPrefix(fakeSOPS, "#!/bin/sh\n"))
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":        "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\ndb:\n  password: \"\"\n  port: 5432\n  user: \"\"\n",
+		"apps/example/values.yaml":  "#@data/values\n---\ntemplates: [secret.yaml]\n",
+		"apps/example/secrets.yaml": encryptedValues,
+		"templates/secret.yaml":     "#@ load(\"@ytt:data\", \"data\")\nkind: Secret\nstringData:\n  password: #@ data.values.db.password\n",
+	})
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{
+		basePath + "/apps/{{.Name}}/values.yaml",
+		basePath + "/apps/{{.Name}}/secrets.yaml",
+	}
+
+	app, errE := NewApp("apps", "example", "development", cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	_, err := ParseValues(app, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = app.Render(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff("decrypt --input-type yaml --output-type yaml\n", string(got)); diff != "" {
+		t.Errorf("Encrypted files should be decrypted once from stdin: -want, +got:\n%s\n", diff)
+	}
+}
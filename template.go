@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// pathTemplateFuncs are the functions available in path templates, named and
+// ordered like their sprig counterparts so they read the same in pipelines.
+//
+//nolint:gochecknoglobals
+var pathTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"default":    defaultValue,
+	"env":        os.Getenv,
+	"expandenv":  os.ExpandEnv,
+}
+
+// defaultValue returns value unless it is empty, in which case def is returned.
+func defaultValue(def any, value any) any {
+	if value == nil {
+		return def
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() { //nolint:exhaustive
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		if v.Len() == 0 {
+			return def
+		}
+	default:
+		if v.IsZero() {
+			return def
+		}
+	}
+
+	return value
+}
+
+func renderPathTemplate(ts string, data map[string]any) (string, error) {
+	t, err := template.New("app").Funcs(pathTemplateFuncs).Option("missingkey=error").Parse(ts)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// templateData returns the data path templates are rendered with. Custom
+// variables of the kind are available at the top level next to the app
+// fields, which take precedence over them. The owning team from the app
+// metadata overrides a Team variable. Team and Vars are always set, empty if
+// unset, so templates can use default with them.
+func (app *App) templateData() map[string]any {
+	data := map[string]any{"Team": ""}
+
+	for k, v := range app.Vars {
+		data[k] = v
+	}
+
+	if app.Metadata.Team != "" {
+		data["Team"] = app.Metadata.Team
+	}
+
+	data["Name"] = app.Name
+	data["Kind"] = app.BaseApp.Kind
+	data["Env"] = app.Env
+	data["Cluster"] = app.Cluster
+	data["Image"] = app.Image
+	data["Vars"] = app.Vars
+	if app.Vars == nil {
+		data["Vars"] = map[string]string{}
+	}
+
+	return data
+}
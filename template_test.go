@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenderPathTemplates(t *testing.T) {
+	t.Setenv("DYTTY_TEST_REGION", "eu")
+
+	app := &App{
+		BaseApp: BaseApp{
+			Name: "Example",
+			Kind: "apps",
+			Env:  Environment{Name: "production", Alias: "prd"},
+			Vars: map[string]string{"Team": "platform", "Name": "ignored"},
+		},
+	}
+
+	cases := map[string]struct {
+		reason    string
+		templates []string
+		want      []string
+	}{
+		"Vars": {
+			reason:    "renderPathTemplates should expose kind variables without overriding app fields",
+			templates: []string{"apps/{{ .Team }}/{{ .Name }}"},
+			want:      []string{"apps/platform/Example"},
+		},
+		"Alias": {
+			reason:    "renderPathTemplates should expose both the typed and normalized env name",
+			templates: []string{"{{ .Env.Alias }}/{{ .Env.Name }}/{{ .Kind }}"},
+			want:      []string{"prd/production/apps"},
+		},
+		"Funcs": {
+			reason: "renderPathTemplates should provide helper functions",
+			templates: []string{
+				"{{ .Name | lower }}",
+				"{{ .Cluster.Name | default \"global\" }}",
+				"{{ .Env.Name | replace \"production\" \"prod\" }}",
+				"{{ env \"DYTTY_TEST_REGION\" }}",
+			},
+			want: []string{"example", "global", "prod", "eu"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := app.renderPathTemplates(tc.templates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nrenderPathTemplates(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestRenderPathTemplatesNoTeam(t *testing.T) {
+	app := &App{BaseApp: BaseApp{Name: "example"}}
+
+	templates := []string{
+		"{{ .Team }}",
+		"{{ .Team | default \"shared\" }}/{{ .Name }}",
+		"{{ default \"shared\" .Team }}",
+	}
+
+	got, err := app.renderPathTemplates(templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"", "shared/example", "shared"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("renderPathTemplates(...) should render an unset team as empty: -want, +got:\n%s\n", diff)
+	}
+}
+
+func TestRenderPathTemplatesMissingKey(t *testing.T) {
+	app := &App{}
+
+	_, err := app.renderPathTemplates([]string{"{{ .Owner }}"})
+	if err == nil {
+		t.Errorf("renderPathTemplates(...): expected an error for an unknown key")
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	yttcmd "carvel.dev/ytt/pkg/cmd/template"
+	yttfiles "carvel.dev/ytt/pkg/files"
+	"gitlab.com/tozd/go/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// DefaultVendorDir is the vendor directory used when none is configured,
+// relative to the config file.
+const DefaultVendorDir = "vendor"
+
+// VendorLockFile is the name of the lock file in the vendor directory.
+const VendorLockFile = "vendor.lock.yaml"
+
+type VendorCommand struct {
+	Directory string    `help:"Directory to vendor libraries into. (default: vendor next to the config file)" name:"directory" placeholder:"PATH" yaml:"directory"`
+	Libraries []Library `kong:"-" yaml:"libraries"`
+	Locked    bool      `help:"Sync the locked revisions and fail if any library differs from the lock file." name:"locked" yaml:"-"`
+}
+
+// Library is a ytt library synced from a local directory, a git bundle or a
+// tarball into the vendor directory, and available to templates as
+// load("@<name>:...").
+type Library struct {
+	Name      string `yaml:"name"`
+	Directory string `yaml:"directory,omitempty"`
+	GitBundle string `yaml:"gitBundle,omitempty"`
+	Tarball   string `yaml:"tarball,omitempty"`
+	// Ref is the git revision to sync from a git bundle, by default HEAD.
+	Ref string `yaml:"ref,omitempty"`
+	// SubPath is the directory inside the source holding the library.
+	SubPath string `yaml:"subPath,omitempty"`
+}
+
+// LockedLibrary records the synced state of a library.
+type LockedLibrary struct {
+	Library `yaml:",inline"`
+	Commit  string `yaml:"commit,omitempty"`
+	Digest  string `yaml:"digest"`
+}
+
+type VendorLock struct {
+	Libraries []LockedLibrary `yaml:"libraries"`
+}
+
+func (c *VendorCommand) vendorDir(cli *CLI) (string, errors.E) {
+	if c.Directory != "" {
+		return c.Directory, nil
+	}
+
+	return cli.ConfigRelative(DefaultVendorDir)
+}
+
+// LoadVendorLock reads the lock file in dir. A missing file results in an empty lock.
+func LoadVendorLock(dir string) (*VendorLock, errors.E) {
+	lock := &VendorLock{}
+
+	data, err := os.ReadFile(filepath.Join(dir, VendorLockFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return lock, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(lock)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.WithDetails(err, "path", filepath.Join(dir, VendorLockFile))
+	}
+
+	return lock, nil
+}
+
+func (l *VendorLock) find(name string) (LockedLibrary, bool) {
+	for _, locked := range l.Libraries {
+		if locked.Name == name {
+			return locked, true
+		}
+	}
+
+	return LockedLibrary{}, false
+}
+
+// DirDigest returns the SHA-256 digest of the paths and contents of all files in dir.
+func DirDigest(dir string) (string, errors.E) {
+	hash := sha256.New()
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		_, _ = fmt.Fprintf(hash, "%s\x00%x\n", filepath.ToSlash(rel), sum)
+
+		return nil
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// vendorPath returns the path of name relative to subPath, and false if it is
+// not inside subPath or not a safe relative path.
+func vendorPath(name, subPath string) (string, bool) {
+	name = path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "./"))
+	subPath = path.Clean(filepath.ToSlash(subPath))
+
+	if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return "", false
+	}
+
+	if subPath != "." {
+		if !strings.HasPrefix(name, subPath+"/") {
+			return "", false
+		}
+
+		name = strings.TrimPrefix(name, subPath+"/")
+	}
+
+	return name, true
+}
+
+func writeVendorFile(dst, name string, mode fs.FileMode, r io.Reader) error {
+	target := filepath.Join(dst, filepath.FromSlash(name))
+
+	err := os.MkdirAll(filepath.Dir(target), 0o755) //nolint:gomnd
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+
+	return err
+}
+
+// copyDir copies the regular files under subPath of src to dst.
+func copyDir(src, dst, subPath string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		name, ok := vendorPath(rel, subPath)
+		if !ok {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return writeVendorFile(dst, name, info.Mode(), file)
+	})
+}
+
+// extractTar extracts the regular files under subPath of the optionally
+// gzip compressed tar archive to dst.
+func extractTar(r io.Reader, dst, subPath string) error {
+	buffered := bufio.NewReader(r)
+
+	magic, err := buffered.Peek(2) //nolint:gomnd
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	reader := io.Reader(buffered)
+
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		reader = gz
+	}
+
+	archive := tar.NewReader(reader)
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name, ok := vendorPath(header.Name, subPath)
+		if !ok {
+			continue
+		}
+
+		err = writeVendorFile(dst, name, header.FileInfo().Mode(), archive) //nolint:gosec
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// syncGitBundle extracts rev (a commit, or else the library ref) from the git
+// bundle to dst and returns the commit.
+func syncGitBundle(library Library, rev, dst string) (string, errors.E) {
+	repo, err := os.MkdirTemp("", "dytty-vendor-*")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer os.RemoveAll(repo)
+
+	if rev == "" {
+		rev = library.Ref
+	}
+
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	_, errE := git("init", "--quiet", "--bare", repo)
+	if errE != nil {
+		return "", errE
+	}
+
+	_, errE = git("-C", repo, "fetch", "--quiet", library.GitBundle, "+refs/*:refs/*", "+HEAD:refs/bundle/HEAD")
+	if errE != nil {
+		// Bundles without HEAD only contain refs.
+		_, errE = git("-C", repo, "fetch", "--quiet", library.GitBundle, "+refs/*:refs/*")
+		if errE != nil {
+			return "", errE
+		}
+	}
+
+	if rev == "HEAD" {
+		rev = "refs/bundle/HEAD"
+	}
+
+	commit, errE := git("-C", repo, "rev-parse", "--verify", rev+"^{commit}")
+	if errE != nil {
+		return "", errE
+	}
+
+	archive, errE := command("git", "-C", repo, "archive", "--format=tar", commit[0])
+	if errE != nil {
+		return "", errE
+	}
+
+	err = extractTar(bytes.NewReader(archive), dst, library.SubPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return commit[0], nil
+}
+
+// syncLibrary syncs the library from its source into the empty directory dst
+// and returns its lock entry. A non-empty commit is synced from a git bundle
+// instead of the library ref.
+func syncLibrary(library Library, dst, commit string) (LockedLibrary, errors.E) {
+	var err error
+
+	locked := LockedLibrary{Library: library}
+
+	switch {
+	case library.Directory != "":
+		err = copyDir(library.Directory, dst, library.SubPath)
+	case library.GitBundle != "":
+		var errE errors.E
+
+		locked.Commit, errE = syncGitBundle(library, commit, dst)
+		if errE != nil {
+			return locked, errE
+		}
+	case library.Tarball != "":
+		var file *os.File
+
+		file, err = os.Open(library.Tarball)
+		if err == nil {
+			defer file.Close()
+
+			err = extractTar(file, dst, library.SubPath)
+		}
+	default:
+		return locked, errors.New("library has no directory, gitBundle or tarball source")
+	}
+
+	if err != nil {
+		return locked, errors.WithStack(err)
+	}
+
+	var errE errors.E
+
+	locked.Digest, errE = DirDigest(dst)
+
+	return locked, errE
+}
+
+// vendorLibrary syncs the library into a temporary directory and checks it
+// against its previous lock entry before replacing the vendored copy in dir.
+// When locked, the synced and the vendored copy have to match the lock entry
+// and the vendored copy is only created when missing, never replaced.
+func (c *VendorCommand) vendorLibrary(library Library, previous LockedLibrary, dir string) (LockedLibrary, errors.E) {
+	err := os.MkdirAll(dir, 0o755) //nolint:gomnd
+	if err != nil {
+		return LockedLibrary{}, errors.WithStack(err)
+	}
+
+	tmp, err := os.MkdirTemp(dir, ".sync-*")
+	if err != nil {
+		return LockedLibrary{}, errors.WithStack(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = os.Chmod(tmp, 0o755) //nolint:gomnd
+	if err != nil {
+		return LockedLibrary{}, errors.WithStack(err)
+	}
+
+	commit := ""
+	if c.Locked {
+		commit = previous.Commit
+	}
+
+	locked, errE := syncLibrary(library, tmp, commit)
+	if errE != nil {
+		return locked, errE
+	}
+
+	dst := filepath.Join(dir, library.Name)
+
+	if c.Locked {
+		if locked.Digest != previous.Digest {
+			return locked, errors.WithDetails(
+				errors.New("library differs from the lock file"),
+				"locked", previous.Digest, "synced", locked.Digest,
+			)
+		}
+
+		_, err = os.Stat(dst)
+		if err == nil {
+			vendored, errE := DirDigest(dst)
+			if errE != nil {
+				return locked, errE
+			}
+
+			if vendored != previous.Digest {
+				return locked, errors.WithDetails(
+					errors.New("vendored library has local changes"),
+					"locked", previous.Digest, "vendored", vendored,
+				)
+			}
+
+			return locked, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return locked, errors.WithStack(err)
+		}
+	}
+
+	err = os.RemoveAll(dst)
+	if err != nil {
+		return locked, errors.WithStack(err)
+	}
+
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		return locked, errors.WithStack(err)
+	}
+
+	return locked, nil
+}
+
+// VendoredLibraries returns the files of the vendored libraries as ytt
+// libraries, i.e., with relative paths in _ytt_lib/<name>.
+func (cli *CLI) VendoredLibraries(opts yttcmd.Options) ([]*yttfiles.File, errors.E) {
+	files := []*yttfiles.File{}
+
+	if len(cli.Vendor.Libraries) == 0 {
+		return files, nil
+	}
+
+	dir, errE := cli.Vendor.vendorDir(cli)
+	if errE != nil {
+		return nil, errE
+	}
+
+	for _, library := range cli.Vendor.Libraries {
+		libraryFiles, err := yttfiles.NewSortedFilesFromPaths([]string{filepath.Join(dir, library.Name)}, *opts.RegularFilesSourceOpts.SymlinkAllowOpts)
+		if err != nil {
+			return nil, errors.WithDetails(err, "library", library.Name, "hint", "run dytty vendor")
+		}
+
+		for _, file := range libraryFiles {
+			file.MarkRelativePath(path.Join("_ytt_lib", library.Name, file.RelativePath()))
+		}
+
+		files = append(files, libraryFiles...)
+	}
+
+	return files, nil
+}
+
+// VendoredLibraryPaths returns the directories of the vendored libraries.
+func (cli *CLI) VendoredLibraryPaths() ([]string, errors.E) {
+	paths := []string{}
+
+	if len(cli.Vendor.Libraries) == 0 {
+		return paths, nil
+	}
+
+	dir, errE := cli.Vendor.vendorDir(cli)
+	if errE != nil {
+		return nil, errE
+	}
+
+	for _, library := range cli.Vendor.Libraries {
+		paths = append(paths, filepath.Join(dir, library.Name))
+	}
+
+	return paths, nil
+}
+
+func (c *VendorCommand) Run(cli *CLI) errors.E {
+	logger := cli.GetLoggingConfig().Logger
+
+	dir, errE := c.vendorDir(cli)
+	if errE != nil {
+		return errE
+	}
+
+	logger.Info().Msgf("Vendoring libraries into: %s", dir)
+
+	lock, errE := LoadVendorLock(dir)
+	if errE != nil {
+		return errE
+	}
+
+	updated := &VendorLock{Libraries: []LockedLibrary{}}
+
+	// When locked, libraries are not removed, so all locked ones have to be declared.
+	if c.Locked {
+		for _, previous := range lock.Libraries {
+			declared := false
+			for _, library := range c.Libraries {
+				declared = declared || library.Name == previous.Name
+			}
+
+			if !declared {
+				return errors.WithDetails(errors.New("library is locked but not declared, run dytty vendor"), "library", previous.Name)
+			}
+		}
+	}
+
+	for _, library := range c.Libraries {
+		previous, found := lock.find(library.Name)
+		if c.Locked && (!found || previous.Library != library) {
+			return errors.WithDetails(errors.New("library is not locked, run dytty vendor"), "library", library.Name)
+		}
+
+		locked, errE := c.vendorLibrary(library, previous, dir)
+		if errE != nil {
+			return errors.WithDetails(errE, "library", library.Name)
+		}
+
+		logger.Info().Msgf("Vendored library %s: %s", library.Name, locked.Digest)
+
+		updated.Libraries = append(updated.Libraries, locked)
+	}
+
+	if c.Locked {
+		return nil
+	}
+
+	// Remove libraries which are not declared anymore.
+	for _, previous := range lock.Libraries {
+		if _, found := updated.find(previous.Name); !found {
+			err := os.RemoveAll(filepath.Join(dir, previous.Name))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(updated)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, VendorLockFile), data, 0o644) //nolint:gomnd,gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
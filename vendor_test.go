@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeTestTarball(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	archive := tar.NewWriter(&buf)
+
+	for name, content := range files {
+		err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = archive.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, buf.Bytes(), 0o644) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVendor(t *testing.T) {
+	sources := t.TempDir()
+	writeTestFiles(t, sources, map[string]string{
+		"local/lib/labels.star": "def labels():\n  return {\"team\": \"platform\"}\nend\n",
+		"local/README.md":       "not vendored",
+		"repo/funcs.star":       "def name():\n  return \"from-git\"\nend\n",
+	})
+	writeTestTarball(t, filepath.Join(sources, "lib.tar"), map[string]string{
+		"package/tarball.star": "def size():\n  return 3\nend\n",
+		"../escape.star":       "",
+	})
+
+	repo := filepath.Join(sources, "repo")
+	for _, args := range [][]string{
+		{"init", "--quiet", repo},
+		{"-C", repo, "add", "."},
+		{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+		{"-C", repo, "tag", "v1"},
+		{"-C", repo, "bundle", "create", "--quiet", filepath.Join(sources, "repo.bundle"), "--all"},
+	} {
+		if _, err := git(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":       "#@data/values\n---\ntemplates: []\napp:\n  image:\n    tag: \"\"\n",
+		"apps/example/values.yaml": "#@data/values\n---\ntemplates: [deployment.yaml]\n",
+		"templates/deployment.yaml": `#@ load("@local:labels.star", "labels")
+#@ load("@git:funcs.star", "name")
+#@ load("@tarball:tarball.star", "size")
+kind: Deployment
+metadata:
+  name: #@ name()
+  labels: #@ labels()
+spec:
+  replicas: #@ size()
+`,
+	})
+
+	vendor := filepath.Join(basePath, "vendor")
+
+	cli := &CLI{BasePath: basePath}
+	cli.Kinds.Apps.Paths.RequiredValues = []string{basePath + "/apps/{{.Name}}/values.yaml"}
+	cli.Vendor = VendorCommand{
+		Directory: vendor,
+		Libraries: []Library{
+			{Name: "local", Directory: filepath.Join(sources, "local"), SubPath: "lib"},
+			{Name: "git", GitBundle: filepath.Join(sources, "repo.bundle"), Ref: "v1"},
+			{Name: "tarball", Tarball: filepath.Join(sources, "lib.tar"), SubPath: "package"},
+		},
+	}
+
+	errE := cli.Vendor.Run(cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	for _, name := range []string{"local/labels.star", "git/funcs.star", "tarball/tarball.star"} {
+		if _, err := os.Stat(filepath.Join(vendor, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(basePath, "escape.star")); !os.IsNotExist(err) {
+		t.Errorf("expected files outside the vendor directory not to be extracted, got %v", err)
+	}
+
+	lock, errE := LoadVendorLock(vendor)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	if len(lock.Libraries) != 3 || lock.Libraries[1].Commit == "" {
+		t.Errorf("unexpected lock: %+v", lock)
+	}
+
+	app, errE := NewApp("apps", "example", "development", cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	got, err := app.Render(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "kind: Deployment\nmetadata:\n  name: from-git\n  labels:\n    team: platform\nspec:\n  replicas: 3\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Render(...): -want, +got:\n%s\n", diff)
+	}
+
+	cli.Vendor.Locked = true
+
+	errE = cli.Vendor.Run(cli)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	writeTestFiles(t, vendor, map[string]string{"git/funcs.star": "edited"})
+
+	errE = cli.Vendor.Run(cli)
+	if errE == nil {
+		t.Error("expected a locally changed library to fail with --locked")
+	}
+
+	data, err := os.ReadFile(filepath.Join(vendor, "git", "funcs.star"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff("edited", string(data)); diff != "" {
+		t.Errorf("expected vendored library not to be replaced with --locked: -want, +got:\n%s\n", diff)
+	}
+
+	writeTestFiles(t, vendor, map[string]string{"git/funcs.star": "def name():\n  return \"from-git\"\nend\n"})
+	writeTestFiles(t, sources, map[string]string{"local/lib/labels.star": "def labels():\n  return {}\nend\n"})
+
+	errE = cli.Vendor.Run(cli)
+	if errE == nil {
+		t.Error("expected a changed library to fail with --locked")
+	}
+}
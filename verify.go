@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+type VerifyCommand struct {
+	Manifest string `arg:"" help:"The render provenance manifest to verify." name:"manifest" type:"path" yaml:"-"`
+}
+
+// LoadRenderManifest reads the render provenance manifest at path.
+func LoadRenderManifest(path string) (*RenderManifest, errors.E) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	manifest := &RenderManifest{}
+
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, errors.WithDetails(err, "path", path)
+	}
+
+	return manifest, nil
+}
+
+// DiffManifests returns the differences between the recorded and the current
+// render provenance manifests, one per line prefixed with their status.
+func DiffManifests(want, got *RenderManifest) []string {
+	diffs := []string{}
+
+	for _, field := range []struct {
+		name      string
+		want, got string
+	}{
+		{"version", want.Version, got.Version},
+		{"revision", want.Revision, got.Revision},
+		{"config", want.Config + " " + want.ConfigDigest, got.Config + " " + got.ConfigDigest},
+		{"gitCommit", want.GitCommit, got.GitCommit},
+		{"configEnv", strings.Join(want.ConfigEnv, ","), strings.Join(got.ConfigEnv, ",")},
+		{"dataValues", strings.Join(want.DataValues, ","), strings.Join(got.DataValues, ",")},
+	} {
+		if field.want != field.got {
+			diffs = append(diffs, fmt.Sprintf("changed  %s: %s -> %s", field.name, field.want, field.got))
+		}
+	}
+
+	diffs = append(diffs, diffFiles("config file", want.ConfigFiles, got.ConfigFiles)...)
+	diffs = append(diffs, diffFiles("input file", want.Files, got.Files)...)
+
+	return diffs
+}
+
+// diffFiles returns the added, changed and removed files, or a changed order
+// of otherwise equal files, described with what.
+func diffFiles(what string, want, got []InputFile) []string {
+	diffs := []string{}
+
+	wantFiles := map[string]string{}
+	for _, file := range want {
+		wantFiles[file.Path] = file.Digest
+	}
+
+	gotFiles := map[string]string{}
+	for _, file := range got {
+		gotFiles[file.Path] = file.Digest
+	}
+
+	for _, file := range got {
+		digest, ok := wantFiles[file.Path]
+
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("added    %s: %s", file.Path, file.Digest))
+		case digest != file.Digest:
+			diffs = append(diffs, fmt.Sprintf("changed  %s: %s -> %s", file.Path, digest, file.Digest))
+		}
+	}
+
+	for _, file := range want {
+		if _, ok := gotFiles[file.Path]; !ok {
+			diffs = append(diffs, fmt.Sprintf("removed  %s: %s", file.Path, file.Digest))
+		}
+	}
+
+	if len(diffs) == 0 && len(want) == len(got) {
+		for i := range want {
+			if want[i].Path != got[i].Path {
+				diffs = append(diffs, fmt.Sprintf("changed  %s order", what))
+
+				break
+			}
+		}
+	}
+
+	return diffs
+}
+
+func (c *VerifyCommand) Run(cli *CLI) errors.E {
+	logger := cli.GetLoggingConfig().Logger
+
+	want, errE := LoadRenderManifest(c.Manifest)
+	if errE != nil {
+		return errE
+	}
+
+	logger.Info().Msgf("Verifying kind: %s, app: %s, env: %s, cluster: %s", want.Kind, want.App, want.Env, want.Cluster)
+
+	// Render the target as recorded.
+	cli.Cluster = want.Cluster
+	cli.ImageTag = want.ImageTag
+	cli.NoSecrets = want.NoSecrets
+
+	app, errE := NewApp(want.Kind, want.App, want.Env, cli)
+	if errE != nil {
+		return errE
+	}
+
+	// The output is sorted and formatted as recorded, so that the bytes printed
+	// are compared.
+	command := &RenderCommand{Format: want.Format, Sort: want.Sort, Phases: cli.Render.Phases}
+
+	var output []byte
+
+	docSet, renderErr := app.RenderDocuments(cli)
+	if renderErr == nil {
+		output, errE = command.output(docSet)
+		if errE != nil {
+			renderErr = errE
+		}
+	}
+
+	// Changed inputs are reported also when they break rendering.
+	got, errE := app.RenderManifest(cli, output)
+	if errE != nil {
+		return errE
+	}
+
+	got.Format = command.Format
+	got.Sort = command.sortStrategy()
+
+	for _, diff := range DiffManifests(want, got) {
+		_, _ = fmt.Fprintln(os.Stdout, diff)
+	}
+
+	if renderErr != nil {
+		return errors.New(cli.NewRedactor(app).String(renderErr.Error()))
+	}
+
+	if want.OutputDigest != got.OutputDigest {
+		_, _ = fmt.Fprintf(os.Stdout, "differs  output: %s -> %s\n", want.OutputDigest, got.OutputDigest)
+
+		return errors.Errorf("output of %s/%s in %s is not byte-identical to the manifest", want.Kind, want.App, want.Env)
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "ok       output: %s\n", got.OutputDigest)
+
+	return nil
+}
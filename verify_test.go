@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffManifests(t *testing.T) {
+	want := &RenderManifest{
+		Version:     "1.0.0",
+		ConfigFiles: []InputFile{{Path: "teams/a.yaml", Digest: "sha256:e"}, {Path: ".dytty.yaml", Digest: "sha256:g"}},
+		ConfigEnv:   []string{"DYTTY_CLUSTER=us-east-1"},
+		DataValues:  []string{"app.image.tag=1.2.3"},
+		Files: []InputFile{
+			{Path: "global/values.yaml", Digest: "sha256:a"},
+			{Path: "apps/example/values.yaml", Digest: "sha256:b"},
+			{Path: "templates/deployment.yaml", Digest: "sha256:c"},
+		},
+	}
+
+	cases := map[string]struct {
+		reason string
+		got    RenderManifest
+		want   []string
+	}{
+		"Identical": {
+			reason: "Identical manifests should have no differences",
+			got:    *want,
+			want:   []string{},
+		},
+		"Changed": {
+			reason: "Changed, added and removed input files and fields should be reported",
+			got: RenderManifest{
+				Version:     "1.1.0",
+				ConfigFiles: want.ConfigFiles,
+				ConfigEnv:   want.ConfigEnv,
+				DataValues:  []string{"app.image.tag=1.2.4"},
+				Files: []InputFile{
+					{Path: "global/values.yaml", Digest: "sha256:a"},
+					{Path: "templates/deployment.yaml", Digest: "sha256:d"},
+					{Path: "charts/redis.yaml", Digest: "sha256:e", Generated: true},
+				},
+			},
+			want: []string{
+				"changed  version: 1.0.0 -> 1.1.0",
+				"changed  dataValues: app.image.tag=1.2.3 -> app.image.tag=1.2.4",
+				"changed  templates/deployment.yaml: sha256:c -> sha256:d",
+				"added    charts/redis.yaml: sha256:e",
+				"removed  apps/example/values.yaml: sha256:b",
+			},
+		},
+		"ConfigChanged": {
+			reason: "Changed config files and environment variable overrides should be reported",
+			got: RenderManifest{
+				Version:     "1.0.0",
+				ConfigFiles: []InputFile{{Path: "teams/a.yaml", Digest: "sha256:f"}, {Path: ".dytty.yaml", Digest: "sha256:g"}},
+				ConfigEnv:   []string{"DYTTY_BASE_PATH=other"},
+				DataValues:  []string{"app.image.tag=1.2.3"},
+				Files:       want.Files,
+			},
+			want: []string{
+				"changed  configEnv: DYTTY_CLUSTER=us-east-1 -> DYTTY_BASE_PATH=other",
+				"changed  teams/a.yaml: sha256:e -> sha256:f",
+			},
+		},
+		"Reordered": {
+			reason: "A changed order of input files should be reported",
+			got: RenderManifest{
+				Version:     "1.0.0",
+				ConfigFiles: want.ConfigFiles,
+				ConfigEnv:   want.ConfigEnv,
+				DataValues:  []string{"app.image.tag=1.2.3"},
+				Files:       []InputFile{want.Files[1], want.Files[0], want.Files[2]},
+			},
+			want: []string{"changed  input file order"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := DiffManifests(want, &tc.got)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("\n%s\nDiffManifests(...): -want, +got:\n%s\n", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestVerifyCommandRun(t *testing.T) {
+	writeTestCommand(t, "git", "#!/bin/sh\necho 0123456789abcdef\n")
+
+	template := "#@ load(\"@ytt:data\", \"data\")\nkind: Deployment\nimage: #@ data.values.app.image.tag\n"
+
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":        "#@data/values\n---\ntemplates: [deployment.yaml]\napp:\n  image:\n    tag: \"\"\n",
+		"templates/deployment.yaml": template,
+	})
+
+	render := &CLI{BasePath: basePath, ImageTag: "1.2.3"}
+
+	app, errE := NewApp("apps", "example", "development", render)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	output, err := app.Render(render)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, errE := app.RenderManifest(render, output)
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(basePath, RenderManifestFile)
+
+	err = os.WriteFile(path, data, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		reason   string
+		template string
+		err      bool
+	}{
+		"Identical": {
+			reason:   "Verify should succeed when the output is reproduced",
+			template: template,
+		},
+		"ChangedInput": {
+			reason:   "Verify should succeed when changed inputs render the same output",
+			template: "#@ load(\"@ytt:data\", \"data\")\n#! unchanged\nkind: Deployment\nimage: #@ data.values.app.image.tag\n",
+		},
+		"ChangedOutput": {
+			reason:   "Verify should fail when the output differs",
+			template: "kind: Deployment\n",
+			err:      true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			writeTestFiles(t, basePath, map[string]string{"templates/deployment.yaml": tc.template})
+
+			errE := (&VerifyCommand{Manifest: path}).Run(&CLI{BasePath: basePath})
+			if (errE != nil) != tc.err {
+				t.Errorf("\n%s\nRun(...): unexpected error: %v\n", tc.reason, errE)
+			}
+		})
+	}
+}
+
+func TestVerifyCommandFormat(t *testing.T) {
+	basePath := t.TempDir()
+	writeTestFiles(t, basePath, map[string]string{
+		"global/values.yaml":        "#@data/values\n---\ntemplates: [deployment.yaml]\napp:\n  image:\n    tag: \"\"\n",
+		"templates/deployment.yaml": "kind: Deployment\n---\nkind: Namespace\n",
+	})
+
+	path := filepath.Join(basePath, RenderManifestFile)
+	render := &RenderCommand{Kind: "apps", App: "example", Env: "development", Format: FormatJSON, Sort: SortKapp, Provenance: path}
+
+	errE := render.Run(&CLI{BasePath: basePath})
+	if errE != nil {
+		t.Fatal(errE)
+	}
+
+	cases := map[string]struct {
+		reason   string
+		template string
+		err      bool
+	}{
+		"Identical": {
+			reason:   "Verify should render in the recorded format and sort to reproduce the output",
+			template: "kind: Deployment\n---\nkind: Namespace\n",
+		},
+		"Reordered": {
+			reason:   "Verify should sort documents as recorded",
+			template: "kind: Namespace\n---\nkind: Deployment\n",
+		},
+		"ChangedOutput": {
+			reason:   "Verify should fail when the formatted output differs",
+			template: "kind: Deployment\n---\nkind: Namespace\nmetadata: {}\n",
+			err:      true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			writeTestFiles(t, basePath, map[string]string{"templates/deployment.yaml": tc.template})
+
+			errE := (&VerifyCommand{Manifest: path}).Run(&CLI{BasePath: basePath})
+			if (errE != nil) != tc.err {
+				t.Errorf("\n%s\nRun(...): unexpected error: %v\n", tc.reason, errE)
+			}
+		})
+	}
+}